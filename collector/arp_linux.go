@@ -16,14 +16,11 @@
 package collector
 
 import (
-	"bufio"
 	"fmt"
-	"io"
-	"os"
-	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
 )
 
 type arpCollector struct {
@@ -48,45 +45,19 @@ func NewARPCollector(logger log.Logger) (Collector, error) {
 }
 
 func getARPEntries() (map[string]uint32, error) {
-	file, err := os.Open(procFilePath("net/arp"))
+	fs, err := procfs.NewFS(*procPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
 	}
-	defer file.Close()
-
-	entries, err := parseARPEntries(file)
+	arpEntries, err := fs.GatherARPEntries()
 	if err != nil {
 		return nil, err
 	}
 
-	return entries, nil
-}
-
-// TODO: This should get extracted to the github.com/prometheus/procfs package
-// to support more complete parsing of /proc/net/arp. Instead of adding
-// more fields to this function's return values it should get moved and
-// changed to support each field.
-func parseARPEntries(data io.Reader) (map[string]uint32, error) {
-	scanner := bufio.NewScanner(data)
 	entries := make(map[string]uint32)
-
-	for scanner.Scan() {
-		columns := strings.Fields(scanner.Text())
-
-		if len(columns) < 6 {
-			return nil, fmt.Errorf("unexpected ARP table format")
-		}
-
-		if columns[0] != "IP" {
-			deviceIndex := len(columns) - 1
-			entries[columns[deviceIndex]]++
-		}
+	for _, entry := range arpEntries {
+		entries[entry.Device]++
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to parse ARP info: %w", err)
-	}
-
 	return entries, nil
 }
 
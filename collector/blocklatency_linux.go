@@ -0,0 +1,297 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noblocklatency
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const blockLatencySubsystem = "block_io"
+
+var (
+	blockLatencyBuckets = kingpin.Flag("collector.blocklatency.latency-buckets", "Comma separated upper bounds in seconds of the block I/O latency histogram buckets.").
+				Default("0.0001,0.00025,0.0005,0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10").String()
+	blockSizeBuckets = kingpin.Flag("collector.blocklatency.size-buckets", "Comma separated upper bounds in bytes of the block I/O size histogram buckets.").
+				Default("4096,8192,16384,32768,65536,131072,262144,524288,1048576").String()
+	blockLatencyMaxInflight = kingpin.Flag("collector.blocklatency.max-inflight", "Maximum number of issued block I/O requests to track until their completion.").
+				Default("10000").Int()
+	blockLatencyBufferPages = kingpin.Flag("collector.blocklatency.buffer-pages", "Number of memory pages of the per CPU perf buffer for block I/O events, must be a power of two.").
+				Default("64").Int()
+
+	// The tracker fed by the block I/O tracepoints on all CPUs.
+	blockTracerOnce sync.Once
+	blockTracer     *blockIOTracker
+	blockTracerErr  error
+)
+
+const (
+	// Requests not completed within this time are considered lost, e.g.
+	// because their completion event was dropped.
+	blockLatencyInflightTimeout = time.Minute
+	// How often requests are checked against the inflight timeout.
+	blockLatencyExpireInterval = 10 * time.Second
+)
+
+type blockLatencyCollector struct {
+	latency *prometheus.Desc
+	size    *prometheus.Desc
+	dropped *prometheus.Desc
+	tracker *blockIOTracker
+	logger  log.Logger
+}
+
+func init() {
+	registerCollector("blocklatency", defaultDisabled, NewBlockLatencyCollector)
+}
+
+// NewBlockLatencyCollector returns a new Collector exposing block I/O latency
+// and size histograms from the block layer tracepoints.
+func NewBlockLatencyCollector(logger log.Logger) (Collector, error) {
+	blockTracerOnce.Do(func() {
+		latencyBuckets, err := parseBuckets(*blockLatencyBuckets)
+		if err != nil {
+			blockTracerErr = fmt.Errorf("invalid latency buckets: %w", err)
+			return
+		}
+		sizeBuckets, err := parseBuckets(*blockSizeBuckets)
+		if err != nil {
+			blockTracerErr = fmt.Errorf("invalid size buckets: %w", err)
+			return
+		}
+		blockTracer = newBlockIOTracker(latencyBuckets, sizeBuckets, *blockLatencyMaxInflight)
+		blockTracerErr = traceBlockIO(blockTracer, *blockLatencyBufferPages, logger)
+	})
+	if blockTracerErr != nil {
+		return nil, blockTracerErr
+	}
+
+	return &blockLatencyCollector{
+		latency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, blockLatencySubsystem, "latency_seconds"),
+			"Histogram of the time between issuing block I/O requests to the device driver and their completion.",
+			[]string{"device", "op"}, nil,
+		),
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, blockLatencySubsystem, "size_bytes"),
+			"Histogram of the size of completed block I/O requests.",
+			[]string{"device", "op"}, nil,
+		),
+		dropped: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, blockLatencySubsystem, "dropped_events_total"),
+			"Number of block I/O events which could not be accounted for, by reason.",
+			[]string{"reason"}, nil,
+		),
+		tracker: blockTracer,
+		logger:  logger,
+	}, nil
+}
+
+func (c *blockLatencyCollector) Update(ch chan<- prometheus.Metric) error {
+	c.tracker.mtx.Lock()
+	defer c.tracker.mtx.Unlock()
+
+	for k, h := range c.tracker.latency {
+		ch <- prometheus.MustNewConstHistogram(c.latency, h.count, h.sum, h.cumulative(), blockDeviceLabel(k.dev), k.op)
+	}
+	for k, h := range c.tracker.size {
+		ch <- prometheus.MustNewConstHistogram(c.size, h.count, h.sum, h.cumulative(), blockDeviceLabel(k.dev), k.op)
+	}
+	for _, reason := range blockIODropReasons {
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(c.tracker.dropped[reason]), reason)
+	}
+	return nil
+}
+
+// blockDeviceLabel returns the kernel name of a block device, or its device
+// number if it has none.
+func blockDeviceLabel(dev uint32) string {
+	// Tracepoints use the kernel internal device number encoding.
+	major, minor := dev>>20, dev&0xfffff
+	number := fmt.Sprintf("%d:%d", major, minor)
+	target, err := os.Readlink(sysFilePath(filepath.Join("dev/block", number)))
+	if err != nil {
+		return number
+	}
+	return filepath.Base(target)
+}
+
+// blockIOOp classifies a request by its rwbs flags, see blk_fill_rwbs().
+func blockIOOp(rwbs string) string {
+	switch {
+	case strings.HasPrefix(rwbs, "F") && !strings.ContainsAny(rwbs, "RWD"):
+		return "flush"
+	case strings.Contains(rwbs, "D"):
+		return "discard"
+	case strings.Contains(rwbs, "R"):
+		return "read"
+	case strings.Contains(rwbs, "W"):
+		return "write"
+	default:
+		return "other"
+	}
+}
+
+const (
+	blockIOIssue = iota
+	blockIOComplete
+)
+
+// blockIOEvent is a block_rq_issue or block_rq_complete tracepoint event.
+type blockIOEvent struct {
+	kind   int
+	time   uint64 // Nanoseconds, from the perf clock.
+	dev    uint32
+	sector uint64
+	bytes  uint64
+	rwbs   string
+
+	// Whether an unmatched completion was already held back for a batch.
+	held bool
+}
+
+var blockIODropReasons = []string{"expired", "inflight_limit", "lost", "unmatched"}
+
+type blockIOKey struct {
+	dev    uint32
+	sector uint64
+}
+
+type blockIOHistogramKey struct {
+	dev uint32
+	op  string
+}
+
+type blockIOIssued struct {
+	time  uint64
+	bytes uint64
+}
+
+// blockIOTracker matches issued and completed block I/O requests and keeps
+// histograms of their latency and size.
+type blockIOTracker struct {
+	latencyBuckets []float64
+	sizeBuckets    []float64
+	maxInflight    int
+
+	mtx      sync.Mutex
+	inflight map[blockIOKey]blockIOIssued
+	latency  map[blockIOHistogramKey]*histogram
+	size     map[blockIOHistogramKey]*histogram
+	dropped  map[string]uint64
+	lastTime uint64
+	// Completions without an issue in their batch, see handle.
+	pending []blockIOEvent
+}
+
+func newBlockIOTracker(latencyBuckets, sizeBuckets []float64, maxInflight int) *blockIOTracker {
+	return &blockIOTracker{
+		latencyBuckets: latencyBuckets,
+		sizeBuckets:    sizeBuckets,
+		maxInflight:    maxInflight,
+		inflight:       map[blockIOKey]blockIOIssued{},
+		latency:        map[blockIOHistogramKey]*histogram{},
+		size:           map[blockIOHistogramKey]*histogram{},
+		dropped:        map[string]uint64{},
+	}
+}
+
+// handle accounts for a batch of events, which are processed in time order
+// as the events of different CPUs are read from separate buffers. As the
+// buffers are not read at the same instant, the issue of a request may only
+// be read with the next batch, so completions without an issue are held back
+// for one batch before they are counted as unmatched.
+func (t *blockIOTracker) handle(events []blockIOEvent) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	events = append(t.pending, events...)
+	t.pending = nil
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time < events[j].time })
+
+	for _, e := range events {
+		if e.time > t.lastTime {
+			t.lastTime = e.time
+		}
+		key := blockIOKey{e.dev, e.sector}
+		switch e.kind {
+		case blockIOIssue:
+			if _, ok := t.inflight[key]; !ok && len(t.inflight) >= t.maxInflight {
+				t.dropped["inflight_limit"]++
+				continue
+			}
+			t.inflight[key] = blockIOIssued{time: e.time, bytes: e.bytes}
+		case blockIOComplete:
+			issued, ok := t.inflight[key]
+			if !ok {
+				if e.held {
+					t.dropped["unmatched"]++
+				} else {
+					e.held = true
+					t.pending = append(t.pending, e)
+				}
+				continue
+			}
+			delete(t.inflight, key)
+			hkey := blockIOHistogramKey{e.dev, blockIOOp(e.rwbs)}
+			t.histogram(t.latency, hkey, t.latencyBuckets).observe(float64(e.time-issued.time) / float64(time.Second))
+			t.histogram(t.size, hkey, t.sizeBuckets).observe(float64(issued.bytes))
+		}
+	}
+}
+
+// lost accounts for events dropped by the kernel.
+func (t *blockIOTracker) lost(n uint64) {
+	t.mtx.Lock()
+	t.dropped["lost"] += n
+	t.mtx.Unlock()
+}
+
+// expire removes requests which were issued longer ago than the inflight
+// timeout.
+func (t *blockIOTracker) expire() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	timeout := uint64(blockLatencyInflightTimeout)
+	if t.lastTime < timeout {
+		return
+	}
+	for k, issued := range t.inflight {
+		if issued.time < t.lastTime-timeout {
+			delete(t.inflight, k)
+			t.dropped["expired"]++
+		}
+	}
+}
+
+func (t *blockIOTracker) histogram(m map[blockIOHistogramKey]*histogram, key blockIOHistogramKey, buckets []float64) *histogram {
+	h, ok := m[key]
+	if !ok {
+		h = newHistogram(buckets)
+		m[key] = h
+	}
+	return h
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noblocklatency
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// blockSample encodes the body of a perf sample record of a block I/O
+// tracepoint with the layout of the fixture format files.
+func blockSample(id uint16, t uint64, dev uint32, sector uint64, nrSector, bytes uint32, rwbs string) []byte {
+	raw := make([]byte, 60)
+	nativeEndian.PutUint16(raw[0:], id)
+	nativeEndian.PutUint32(raw[8:], dev)
+	nativeEndian.PutUint64(raw[16:], sector)
+	nativeEndian.PutUint32(raw[24:], nrSector)
+	if id == 1137 {
+		nativeEndian.PutUint32(raw[28:], bytes)
+	}
+	copy(raw[32:40], rwbs)
+
+	record := make([]byte, 12, 12+len(raw))
+	nativeEndian.PutUint64(record[0:], t)
+	nativeEndian.PutUint32(record[8:], uint32(len(raw)))
+	return append(record, raw...)
+}
+
+func TestBlockEventDecoder(t *testing.T) {
+	d, err := newBlockEventDecoder("fixtures/tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := uint32(8<<20 | 1)
+
+	tests := []struct {
+		sample []byte
+		want   blockIOEvent
+	}{
+		{
+			sample: blockSample(1137, 1000, dev, 2048, 8, 4096, "WS"),
+			want:   blockIOEvent{kind: blockIOIssue, time: 1000, dev: dev, sector: 2048, bytes: 4096, rwbs: "WS"},
+		},
+		{
+			// block_rq_complete has no bytes field.
+			sample: blockSample(1139, 2000, dev, 2048, 8, 0, "WS"),
+			want:   blockIOEvent{kind: blockIOComplete, time: 2000, dev: dev, sector: 2048, bytes: 4096, rwbs: "WS"},
+		},
+	}
+	for _, test := range tests {
+		got, err := d.decodeSample(test.sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("want %+v, got %+v", test.want, got)
+		}
+	}
+
+	if _, err := d.decodeSample(blockSample(42, 0, dev, 0, 0, 0, "R")); err == nil {
+		t.Error("expected error for unknown tracepoint, got none")
+	}
+	if _, err := d.decodeSample(blockSample(1137, 0, dev, 0, 0, 0, "R")[:40]); err == nil {
+		t.Error("expected error for short sample, got none")
+	}
+}
+
+func TestReadPerfRing(t *testing.T) {
+	data := make([]byte, 40)
+	var records [][]byte
+	// Write three 12 byte records starting at offset 24, so that the header
+	// of the second one wraps around the end of the buffer.
+	head := uint64(24)
+	for i := byte(1); i <= 3; i++ {
+		record := []byte{i, 0, 0, 0, 0, 0, 12, 0, i, i, i, i}
+		for j, b := range record {
+			data[(head+uint64(j))%uint64(len(data))] = b
+		}
+		head += uint64(len(record))
+		records = append(records, record[8:])
+	}
+
+	var got [][]byte
+	tail := readPerfRing(data, head, 24, func(typ uint32, record []byte) {
+		if want := uint32(len(got) + 1); typ != want {
+			t.Errorf("want type %d, got %d", want, typ)
+		}
+		got = append(got, append([]byte{}, record...))
+	})
+	if tail != head {
+		t.Errorf("want tail %d, got %d", head, tail)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("want records %v, got %v", records, got)
+	}
+}
+
+func TestBlockIOTracker(t *testing.T) {
+	ms := uint64(time.Millisecond)
+	sda, sdb := uint32(8<<20), uint32(8<<20|16)
+	tracker := newBlockIOTracker([]float64{0.001, 0.01, 0.1}, []float64{4096, 65536}, 3)
+
+	// Events of different CPUs arrive out of order.
+	tracker.handle([]blockIOEvent{
+		{kind: blockIOComplete, time: 5 * ms, dev: sda, sector: 8, rwbs: "R"},
+		{kind: blockIOIssue, time: 0, dev: sda, sector: 8, bytes: 4096, rwbs: "R"},
+		{kind: blockIOIssue, time: 1 * ms, dev: sda, sector: 16, bytes: 8192, rwbs: "W"},
+		{kind: blockIOIssue, time: 1 * ms, dev: sdb, sector: 16, bytes: 1 << 20, rwbs: "WS"},
+		{kind: blockIOIssue, time: 2 * ms, dev: sdb, sector: 32, bytes: 4096, rwbs: "R"},
+		{kind: blockIOComplete, time: 3 * ms, dev: sdb, sector: 64, rwbs: "R"},
+		{kind: blockIOIssue, time: 6 * ms, dev: sdb, sector: 48, bytes: 4096, rwbs: "R"},
+	})
+	tracker.handle([]blockIOEvent{
+		{kind: blockIOComplete, time: 1500 * ms, dev: sda, sector: 16, rwbs: "W"},
+		{kind: blockIOComplete, time: 201 * ms, dev: sdb, sector: 16, rwbs: "WS"},
+	})
+	// The read of sdb is never completed.
+	tracker.handle([]blockIOEvent{
+		{kind: blockIOIssue, time: 2 * uint64(time.Minute), dev: sda, sector: 24, bytes: 512, rwbs: "FWS"},
+	})
+	tracker.expire()
+
+	latency := map[blockIOHistogramKey]map[float64]uint64{
+		{sda, "read"}:  {0.001: 0, 0.01: 1, 0.1: 1},
+		{sda, "write"}: {0.001: 0, 0.01: 0, 0.1: 0},
+		{sdb, "write"}: {0.001: 0, 0.01: 0, 0.1: 0},
+	}
+	for k, want := range latency {
+		h, ok := tracker.latency[k]
+		if !ok {
+			t.Errorf("%v: missing latency histogram", k)
+			continue
+		}
+		if got := h.cumulative(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: want latency buckets %v, got %v", k, want, got)
+		}
+		if h.count != 1 {
+			t.Errorf("%v: want count 1, got %d", k, h.count)
+		}
+	}
+	if got := tracker.latency[blockIOHistogramKey{sda, "write"}].sum; got != 1.499 {
+		t.Errorf("want latency sum 1.499, got %v", got)
+	}
+
+	size := map[blockIOHistogramKey]map[float64]uint64{
+		{sda, "read"}:  {4096: 1, 65536: 1},
+		{sda, "write"}: {4096: 0, 65536: 1},
+		{sdb, "write"}: {4096: 0, 65536: 0},
+	}
+	for k, want := range size {
+		if got := tracker.size[k].cumulative(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: want size buckets %v, got %v", k, want, got)
+		}
+	}
+
+	dropped := map[string]uint64{"inflight_limit": 1, "unmatched": 1, "expired": 1}
+	if !reflect.DeepEqual(tracker.dropped, dropped) {
+		t.Errorf("want dropped %v, got %v", dropped, tracker.dropped)
+	}
+	if len(tracker.inflight) != 1 {
+		t.Errorf("want 1 inflight request, got %d", len(tracker.inflight))
+	}
+}
+
+func TestBlockIOTrackerReordering(t *testing.T) {
+	ms := uint64(time.Millisecond)
+	sda := uint32(8 << 20)
+	tracker := newBlockIOTracker([]float64{0.001, 0.01, 0.1}, []float64{4096, 65536}, 10)
+
+	// The issue of a request is read from the buffer of another CPU only
+	// after its completion.
+	tracker.handle([]blockIOEvent{
+		{kind: blockIOComplete, time: 5 * ms, dev: sda, sector: 8, rwbs: "R"},
+	})
+	tracker.handle([]blockIOEvent{
+		{kind: blockIOIssue, time: 2 * ms, dev: sda, sector: 8, bytes: 4096, rwbs: "R"},
+	})
+
+	h, ok := tracker.latency[blockIOHistogramKey{sda, "read"}]
+	if !ok || h.count != 1 {
+		t.Fatalf("want completed read, got %+v", tracker.latency)
+	}
+	if len(tracker.dropped) != 0 || len(tracker.inflight) != 0 || len(tracker.pending) != 0 {
+		t.Errorf("want no dropped, inflight or pending requests, got %v, %v, %v", tracker.dropped, tracker.inflight, tracker.pending)
+	}
+}
+
+func TestBlockIOOp(t *testing.T) {
+	for rwbs, want := range map[string]string{
+		"R":   "read",
+		"RA":  "read",
+		"WS":  "write",
+		"WFS": "write",
+		"FWS": "write",
+		"FF":  "flush",
+		"DS":  "discard",
+		"N":   "other",
+	} {
+		if got := blockIOOp(rwbs); got != want {
+			t.Errorf("%s: want %s, got %s", rwbs, want, got)
+		}
+	}
+}
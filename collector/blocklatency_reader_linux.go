@@ -0,0 +1,381 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noblocklatency
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hodgesds/perf-utils"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	blockLatencyPollInterval = 100 * time.Millisecond
+
+	// Offsets of data_head and data_tail in struct perf_event_mmap_page.
+	perfDataHeadOffset = 1024
+	perfDataTailOffset = 1032
+	perfHeaderSize     = 8
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// traceBlockIO attaches to the block_rq_issue and block_rq_complete
+// tracepoints on all CPUs and feeds their events to the tracker in the
+// background.
+func traceBlockIO(tracker *blockIOTracker, pages int, logger log.Logger) error {
+	if pages <= 0 || pages&(pages-1) != 0 {
+		return fmt.Errorf("perf buffer pages must be a power of two, got %d", pages)
+	}
+	decoder, err := newBlockEventDecoder(perf.TracingDir)
+	if err != nil {
+		return err
+	}
+
+	var rings []*perfRing
+	for cpu := 0; cpu < runtime.NumCPU(); cpu++ {
+		ring, err := openBlockIORing(cpu, pages)
+		if err != nil {
+			for _, r := range rings {
+				r.close()
+			}
+			return fmt.Errorf("failed to trace block I/O on CPU %d: %w", cpu, err)
+		}
+		rings = append(rings, ring)
+	}
+
+	go func() {
+		ticker := time.NewTicker(blockLatencyPollInterval)
+		defer ticker.Stop()
+		expireTicker := time.NewTicker(blockLatencyExpireInterval)
+		defer expireTicker.Stop()
+		for {
+			select {
+			case <-expireTicker.C:
+				tracker.expire()
+			case <-ticker.C:
+				// The events of all rings are handled as one batch, so that
+				// they are processed in time order.
+				var events []blockIOEvent
+				for _, ring := range rings {
+					ring.read(func(typ uint32, record []byte) {
+						switch typ {
+						case unix.PERF_RECORD_SAMPLE:
+							e, err := decoder.decodeSample(record)
+							if err != nil {
+								level.Debug(logger).Log("msg", "failed to decode block I/O event", "err", err)
+								return
+							}
+							events = append(events, e)
+						case unix.PERF_RECORD_LOST:
+							if len(record) >= 16 {
+								tracker.lost(nativeEndian.Uint64(record[8:16]))
+							}
+						}
+					})
+				}
+				tracker.handle(events)
+			}
+		}
+	}()
+	return nil
+}
+
+// perfRing is a perf event ring buffer, see perf_event_open(2). Unlike the
+// counting profilers of perfTracepointCollector, it provides the time and raw
+// data of each tracepoint event needed to match issues and completions.
+type perfRing struct {
+	fds  []int
+	mmap []byte
+}
+
+// openBlockIORing opens both block I/O tracepoints on a CPU, with the events
+// of both written to a single ring buffer to keep them in order.
+func openBlockIORing(cpu, pages int) (*perfRing, error) {
+	ring := &perfRing{}
+	for _, event := range []string{"block_rq_issue", "block_rq_complete"} {
+		attr, err := perf.TracepointEventAttr("block", event)
+		if err != nil {
+			ring.close()
+			return nil, err
+		}
+		attr.Sample_type = unix.PERF_SAMPLE_TIME | unix.PERF_SAMPLE_RAW
+		attr.Sample = 1
+		attr.Read_format = 0
+		fd, err := unix.PerfEventOpen(attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			ring.close()
+			return nil, err
+		}
+		ring.fds = append(ring.fds, fd)
+	}
+
+	var err error
+	ring.mmap, err = unix.Mmap(ring.fds[0], 0, (pages+1)*os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		ring.close()
+		return nil, err
+	}
+	if err := unix.IoctlSetInt(ring.fds[1], unix.PERF_EVENT_IOC_SET_OUTPUT, ring.fds[0]); err != nil {
+		ring.close()
+		return nil, err
+	}
+	for _, fd := range ring.fds {
+		if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			ring.close()
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+func (r *perfRing) read(fn func(typ uint32, record []byte)) {
+	head := atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.mmap[perfDataHeadOffset])))
+	tail := atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.mmap[perfDataTailOffset])))
+	tail = readPerfRing(r.mmap[os.Getpagesize():], head, tail, fn)
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&r.mmap[perfDataTailOffset])), tail)
+}
+
+func (r *perfRing) close() {
+	if r.mmap != nil {
+		unix.Munmap(r.mmap)
+	}
+	for _, fd := range r.fds {
+		unix.Close(fd)
+	}
+}
+
+// readPerfRing calls fn with the type and body of each record between tail
+// and head of the data area of a ring buffer, and returns the new tail.
+func readPerfRing(data []byte, head, tail uint64, fn func(typ uint32, record []byte)) uint64 {
+	for tail+perfHeaderSize <= head {
+		header := perfRingBytes(data, tail, perfHeaderSize)
+		size := uint64(nativeEndian.Uint16(header[6:8]))
+		if size < perfHeaderSize || tail+size > head {
+			break
+		}
+		fn(nativeEndian.Uint32(header[0:4]), perfRingBytes(data, tail+perfHeaderSize, size-perfHeaderSize))
+		tail += size
+	}
+	return tail
+}
+
+// perfRingBytes returns n bytes at offset off of a ring buffer, copying them
+// if they wrap around its end.
+func perfRingBytes(data []byte, off, n uint64) []byte {
+	size := uint64(len(data))
+	start := off % size
+	if start+n <= size {
+		return data[start : start+n]
+	}
+	b := make([]byte, n)
+	copied := copy(b, data[start:])
+	copy(b[copied:], data)
+	return b
+}
+
+// tracepointField is the location of a field in the raw data of a tracepoint
+// event.
+type tracepointField struct {
+	offset int
+	size   int
+}
+
+// parseTracepointFormat parses the fields of a tracepoint format file.
+func parseTracepointFormat(r io.Reader) (map[string]tracepointField, error) {
+	fields := map[string]tracepointField{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "field:") {
+			continue
+		}
+		var (
+			name  string
+			field tracepointField
+			err   error
+		)
+		for _, part := range strings.Split(line, ";") {
+			kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "field":
+				decl := strings.Fields(kv[1])
+				if len(decl) == 0 {
+					return nil, fmt.Errorf("invalid tracepoint field %q", line)
+				}
+				name = decl[len(decl)-1]
+				if i := strings.Index(name, "["); i >= 0 {
+					name = name[:i]
+				}
+			case "offset":
+				field.offset, err = strconv.Atoi(kv[1])
+			case "size":
+				field.size, err = strconv.Atoi(kv[1])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid tracepoint field %q: %w", line, err)
+			}
+		}
+		fields[name] = field
+	}
+	return fields, scanner.Err()
+}
+
+// blockEventFormat is the layout of the raw data of a block I/O tracepoint.
+type blockEventFormat struct {
+	kind     int
+	dev      tracepointField
+	sector   tracepointField
+	nrSector tracepointField
+	bytes    tracepointField // Only set for block_rq_issue.
+	rwbs     tracepointField
+}
+
+// blockEventDecoder decodes block I/O tracepoint samples.
+type blockEventDecoder struct {
+	commonType tracepointField
+	formats    map[uint64]blockEventFormat
+}
+
+func newBlockEventDecoder(tracingDir string) (*blockEventDecoder, error) {
+	d := &blockEventDecoder{formats: map[uint64]blockEventFormat{}}
+	for kind, event := range []string{"block_rq_issue", "block_rq_complete"} {
+		dir := tracingDir + "/events/block/" + event
+		id, err := readUintFromFile(dir + "/id")
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(dir + "/format")
+		if err != nil {
+			return nil, err
+		}
+		fields, err := parseTracepointFormat(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		format := blockEventFormat{kind: kind}
+		for name, f := range map[string]*tracepointField{
+			"common_type": &d.commonType,
+			"dev":         &format.dev,
+			"sector":      &format.sector,
+			"nr_sector":   &format.nrSector,
+			"rwbs":        &format.rwbs,
+		} {
+			field, ok := fields[name]
+			if !ok {
+				return nil, fmt.Errorf("tracepoint block:%s has no field %s", event, name)
+			}
+			*f = field
+		}
+		format.bytes = fields["bytes"]
+		d.formats[id] = format
+	}
+	return d, nil
+}
+
+// decodeSample decodes the body of a sample record with the time and raw
+// data of the tracepoint.
+func (d *blockEventDecoder) decodeSample(record []byte) (blockIOEvent, error) {
+	if len(record) < 12 {
+		return blockIOEvent{}, fmt.Errorf("short sample of %d bytes", len(record))
+	}
+	t := nativeEndian.Uint64(record[0:8])
+	size := int(nativeEndian.Uint32(record[8:12]))
+	if len(record) < 12+size {
+		return blockIOEvent{}, fmt.Errorf("short raw data of %d bytes, want %d", len(record)-12, size)
+	}
+	raw := record[12 : 12+size]
+
+	typ, err := readTracepointField(raw, d.commonType)
+	if err != nil {
+		return blockIOEvent{}, err
+	}
+	format, ok := d.formats[typ]
+	if !ok {
+		return blockIOEvent{}, fmt.Errorf("unknown tracepoint %d", typ)
+	}
+	e := blockIOEvent{kind: format.kind, time: t}
+	dev, err := readTracepointField(raw, format.dev)
+	if err != nil {
+		return blockIOEvent{}, err
+	}
+	e.dev = uint32(dev)
+	if e.sector, err = readTracepointField(raw, format.sector); err != nil {
+		return blockIOEvent{}, err
+	}
+	if format.bytes.size > 0 {
+		e.bytes, err = readTracepointField(raw, format.bytes)
+	} else {
+		e.bytes, err = readTracepointField(raw, format.nrSector)
+		e.bytes *= 512
+	}
+	if err != nil {
+		return blockIOEvent{}, err
+	}
+	if format.rwbs.offset+format.rwbs.size > len(raw) {
+		return blockIOEvent{}, fmt.Errorf("field rwbs out of range")
+	}
+	rwbs := raw[format.rwbs.offset : format.rwbs.offset+format.rwbs.size]
+	if i := bytes.IndexByte(rwbs, 0); i >= 0 {
+		rwbs = rwbs[:i]
+	}
+	e.rwbs = string(rwbs)
+	return e, nil
+}
+
+// readTracepointField reads an unsigned integer field of the raw data of a
+// tracepoint event.
+func readTracepointField(raw []byte, f tracepointField) (uint64, error) {
+	if f.offset+f.size > len(raw) {
+		return 0, fmt.Errorf("field at offset %d out of range", f.offset)
+	}
+	b := raw[f.offset : f.offset+f.size]
+	switch f.size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(nativeEndian.Uint16(b)), nil
+	case 4:
+		return uint64(nativeEndian.Uint32(b)), nil
+	case 8:
+		return nativeEndian.Uint64(b), nil
+	}
+	return 0, fmt.Errorf("unsupported field size %d", f.size)
+}
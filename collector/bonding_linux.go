@@ -16,11 +16,14 @@
 package collector
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/go-kit/kit/log"
@@ -29,8 +32,12 @@ import (
 )
 
 type bondingCollector struct {
-	slaves, active typedDesc
-	logger         log.Logger
+	slaves, active                        typedDesc
+	info, activeAggregator                typedDesc
+	slaveActive, slaveMIIUp               typedDesc
+	slaveLinkFailures                     typedDesc
+	slaveAggregator, slavePartnerMismatch typedDesc
+	logger                                log.Logger
 }
 
 func init() {
@@ -51,6 +58,41 @@ func NewBondingCollector(logger log.Logger) (Collector, error) {
 			"Number of active slaves per bonding interface.",
 			[]string{"master"}, nil,
 		), prometheus.GaugeValue},
+		info: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "info"),
+			"Bonding mode per bonding interface, with a value of 1.",
+			[]string{"master", "mode"}, nil,
+		), prometheus.GaugeValue},
+		activeAggregator: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "active_aggregator_id"),
+			"ID of the active 802.3ad aggregator per bonding interface.",
+			[]string{"master"}, nil,
+		), prometheus.GaugeValue},
+		slaveActive: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slave_active"),
+			"Whether the slave is the currently active slave of a bonding interface with an active slave.",
+			[]string{"master", "slave"}, nil,
+		), prometheus.GaugeValue},
+		slaveMIIUp: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slave_mii_up"),
+			"Whether the MII status of the slave is up.",
+			[]string{"master", "slave"}, nil,
+		), prometheus.GaugeValue},
+		slaveLinkFailures: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slave_link_failures_total"),
+			"Number of link failures of the slave.",
+			[]string{"master", "slave"}, nil,
+		), prometheus.CounterValue},
+		slaveAggregator: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slave_aggregator_id"),
+			"ID of the 802.3ad aggregator of the slave.",
+			[]string{"master", "slave"}, nil,
+		), prometheus.GaugeValue},
+		slavePartnerMismatch: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bonding", "slave_partner_mac_mismatch"),
+			"Whether the 802.3ad partner MAC address of the slave differs from the one of the active aggregator.",
+			[]string{"master", "slave"}, nil,
+		), prometheus.GaugeValue},
 		logger: logger,
 	}, nil
 }
@@ -69,10 +111,50 @@ func (c *bondingCollector) Update(ch chan<- prometheus.Metric) error {
 	for master, status := range bondingStats {
 		ch <- c.slaves.mustNewConstMetric(float64(status[0]), master)
 		ch <- c.active.mustNewConstMetric(float64(status[1]), master)
+
+		procStatus, err := readBondingProcStatus(procFilePath(filepath.Join("net/bonding", master)))
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read bonding status", "master", master, "err", err)
+			continue
+		}
+		c.updateProcStatus(ch, master, procStatus)
 	}
 	return nil
 }
 
+func (c *bondingCollector) updateProcStatus(ch chan<- prometheus.Metric, master string, status *bondingProcStatus) {
+	ch <- c.info.mustNewConstMetric(1, master, status.Mode)
+	if status.AggregatorID != nil {
+		ch <- c.activeAggregator.mustNewConstMetric(float64(*status.AggregatorID), master)
+	}
+
+	for _, slave := range status.Slaves {
+		if status.ActiveSlave != "" {
+			var active float64
+			if slave.Name == status.ActiveSlave {
+				active = 1
+			}
+			ch <- c.slaveActive.mustNewConstMetric(active, master, slave.Name)
+		}
+		var miiUp float64
+		if slave.MIIStatus == "up" {
+			miiUp = 1
+		}
+		ch <- c.slaveMIIUp.mustNewConstMetric(miiUp, master, slave.Name)
+		ch <- c.slaveLinkFailures.mustNewConstMetric(float64(slave.LinkFailures), master, slave.Name)
+		if slave.AggregatorID != nil {
+			ch <- c.slaveAggregator.mustNewConstMetric(float64(*slave.AggregatorID), master, slave.Name)
+		}
+		if slave.PartnerMAC != "" && status.PartnerMAC != "" {
+			var mismatch float64
+			if slave.PartnerMAC != status.PartnerMAC {
+				mismatch = 1
+			}
+			ch <- c.slavePartnerMismatch.mustNewConstMetric(mismatch, master, slave.Name)
+		}
+	}
+}
+
 func readBondingStats(root string) (status map[string][2]int, err error) {
 	status = map[string][2]int{}
 	masters, err := ioutil.ReadFile(filepath.Join(root, "bonding_masters"))
@@ -103,3 +185,117 @@ func readBondingStats(root string) (status map[string][2]int, err error) {
 	}
 	return status, err
 }
+
+// bondingModes maps the bonding modes of /proc/net/bonding to their names in
+// sysfs, see bond_mode_name() in the kernel.
+var bondingModes = map[string]string{
+	"load balancing (round-robin)":          "balance-rr",
+	"fault-tolerance (active-backup)":       "active-backup",
+	"load balancing (xor)":                  "balance-xor",
+	"fault-tolerance (broadcast)":           "broadcast",
+	"IEEE 802.3ad Dynamic link aggregation": "802.3ad",
+	"transmit load balancing":               "balance-tlb",
+	"adaptive load balancing":               "balance-alb",
+}
+
+// bondingProcStatus is the status of a bonding interface from
+// /proc/net/bonding. AggregatorID and PartnerMAC are those of the active
+// aggregator of 802.3ad bonds.
+type bondingProcStatus struct {
+	Mode         string
+	ActiveSlave  string
+	AggregatorID *uint64
+	PartnerMAC   string
+	Slaves       []bondingSlaveStatus
+}
+
+// bondingSlaveStatus is the status of a slave of a bonding interface. The
+// PartnerMAC is only known for 802.3ad bonds on kernels which show the LACP
+// details.
+type bondingSlaveStatus struct {
+	Name         string
+	MIIStatus    string
+	LinkFailures uint64
+	AggregatorID *uint64
+	PartnerMAC   string
+}
+
+func readBondingProcStatus(path string) (*bondingProcStatus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseBondingProcStatus(file)
+}
+
+// parseBondingProcStatus parses a /proc/net/bonding file, which has a section
+// of "key: value" lines for the bond followed by one for each slave. Sections
+// are split into subsections, such as the LACP details of the partner of a
+// slave, by lines ending with a colon.
+func parseBondingProcStatus(r io.Reader) (*bondingProcStatus, error) {
+	var (
+		status     bondingProcStatus
+		slave      *bondingSlaveStatus
+		subsection string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, ":") {
+			subsection = strings.TrimSuffix(line, ":")
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], strings.TrimSpace(parts[1])
+
+		if key == "Slave Interface" {
+			status.Slaves = append(status.Slaves, bondingSlaveStatus{Name: value})
+			slave = &status.Slaves[len(status.Slaves)-1]
+			subsection = ""
+			continue
+		}
+
+		var err error
+		switch {
+		case slave == nil && key == "Bonding Mode":
+			status.Mode = value
+			if mode, ok := bondingModes[value]; ok {
+				status.Mode = mode
+			}
+		case slave == nil && key == "Currently Active Slave" && value != "None":
+			status.ActiveSlave = value
+		case slave == nil && subsection == "Active Aggregator Info" && key == "Aggregator ID":
+			status.AggregatorID, err = parseBondingAggregatorID(value)
+		case slave == nil && subsection == "Active Aggregator Info" && key == "Partner Mac Address":
+			status.PartnerMAC = value
+		case slave != nil && key == "MII Status":
+			slave.MIIStatus = value
+		case slave != nil && key == "Link Failure Count":
+			slave.LinkFailures, err = strconv.ParseUint(value, 10, 64)
+		case slave != nil && key == "Aggregator ID":
+			slave.AggregatorID, err = parseBondingAggregatorID(value)
+		case slave != nil && subsection == "details partner lacp pdu" && key == "system mac address":
+			slave.PartnerMAC = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func parseBondingAggregatorID(value string) (*uint64, error) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
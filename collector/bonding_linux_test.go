@@ -14,6 +14,7 @@
 package collector
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -34,3 +35,35 @@ func TestBonding(t *testing.T) {
 		t.Fatal("dmz in unexpected state")
 	}
 }
+
+func TestBondingProcStatus(t *testing.T) {
+	id := func(v uint64) *uint64 { return &v }
+	for master, want := range map[string]*bondingProcStatus{
+		"bond0": {Mode: "balance-rr"},
+		"int": {
+			Mode:        "active-backup",
+			ActiveSlave: "eth5",
+			Slaves: []bondingSlaveStatus{
+				{Name: "eth5", MIIStatus: "up"},
+				{Name: "eth1", MIIStatus: "down", LinkFailures: 3},
+			},
+		},
+		"dmz": {
+			Mode:         "802.3ad",
+			AggregatorID: id(1),
+			PartnerMAC:   "00:1c:73:aa:bb:01",
+			Slaves: []bondingSlaveStatus{
+				{Name: "eth0", MIIStatus: "up", LinkFailures: 1, AggregatorID: id(1), PartnerMAC: "00:1c:73:aa:bb:01"},
+				{Name: "eth4", MIIStatus: "up", AggregatorID: id(2), PartnerMAC: "00:1c:73:aa:bb:02"},
+			},
+		},
+	} {
+		got, err := readBondingProcStatus("fixtures/proc/net/bonding/" + master)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %+v, got %+v", master, want, got)
+		}
+	}
+}
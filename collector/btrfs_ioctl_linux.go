@@ -0,0 +1,331 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nobtrfs
+
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Btrfs ioctl requests, see include/uapi/linux/btrfs.h.
+var (
+	btrfsIocScrubProgress   = btrfsIoc(3, 29, unsafe.Sizeof(btrfsIoctlScrubArgs{}))
+	btrfsIocDevInfo         = btrfsIoc(3, 30, unsafe.Sizeof(btrfsIoctlDevInfoArgs{}))
+	btrfsIocFSInfo          = btrfsIoc(2, 31, unsafe.Sizeof(btrfsIoctlFSInfoArgs{}))
+	btrfsIocBalanceProgress = btrfsIoc(2, 34, unsafe.Sizeof(btrfsIoctlBalanceArgs{}))
+	btrfsIocGetDevStats     = btrfsIoc(3, 52, unsafe.Sizeof(btrfsIoctlGetDevStats{}))
+)
+
+// btrfsBalanceStateRunning is set in the state of a balance which is not paused.
+const btrfsBalanceStateRunning = 1 << 0
+
+// btrfsIoc encodes an ioctl request of the Btrfs ioctl type, with dir 2 for
+// _IOR and 3 for _IOWR.
+func btrfsIoc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 0x94<<8 | nr
+}
+
+type btrfsIoctlFSInfoArgs struct {
+	maxID      uint64
+	numDevices uint64
+	fsid       [16]byte
+	_          [1024 - 32]byte
+}
+
+type btrfsIoctlDevInfoArgs struct {
+	devid      uint64
+	uuid       [16]byte
+	bytesUsed  uint64
+	totalBytes uint64
+	_          [379]uint64
+	path       [1024]byte
+}
+
+type btrfsIoctlGetDevStats struct {
+	devid   uint64
+	nrItems uint64
+	flags   uint64
+	values  [5]uint64
+	_       [121]uint64
+}
+
+type btrfsIoctlScrubArgs struct {
+	devid    uint64
+	start    uint64
+	end      uint64
+	flags    uint64
+	progress [15]uint64
+	_        [(1024 - 32 - 15*8) / 8]uint64
+}
+
+type btrfsIoctlBalanceArgs struct {
+	flags uint64
+	state uint64
+	_     [3 * 136]byte
+	stat  [3]uint64
+	_     [72]uint64
+}
+
+// btrfsIoctlDevice is a device of a Btrfs filesystem.
+type btrfsIoctlDevice struct {
+	ID   uint64
+	Path string
+}
+
+// btrfsDeviceErrors are the error counters of a Btrfs device.
+type btrfsDeviceErrors struct {
+	Write      uint64
+	Read       uint64
+	Flush      uint64
+	Corruption uint64
+	Generation uint64
+}
+
+// btrfsScrubProgress is the progress of a running scrub of a Btrfs device.
+type btrfsScrubProgress struct {
+	DataBytesScrubbed   uint64
+	TreeBytesScrubbed   uint64
+	ReadErrors          uint64
+	CsumErrors          uint64
+	VerifyErrors        uint64
+	SuperErrors         uint64
+	UncorrectableErrors uint64
+	CorrectedErrors     uint64
+}
+
+// btrfsBalanceProgress is the progress of a running or paused balance of a
+// Btrfs filesystem.
+type btrfsBalanceProgress struct {
+	Running    bool
+	Expected   uint64
+	Considered uint64
+	Completed  uint64
+}
+
+// btrfsIoctlStater is an interface used to swap out the ioctls of a mounted
+// Btrfs filesystem for end to end tests.
+type btrfsIoctlStater interface {
+	Close() error
+	UUID() (string, error)
+	Devices() ([]btrfsIoctlDevice, error)
+	DeviceErrors(devid uint64) (*btrfsDeviceErrors, error)
+	// ScrubProgress returns nil if no scrub is running on the device.
+	ScrubProgress(devid uint64) (*btrfsScrubProgress, error)
+	// BalanceProgress returns nil if there is no balance.
+	BalanceProgress() (*btrfsBalanceProgress, error)
+}
+
+// newBtrfsIoctlStater determines if mocked test fixtures from files should be
+// used for the Btrfs filesystem mounted at mountPoint, or if ioctls should be
+// used.
+func newBtrfsIoctlStater(fixtures, mountPoint string) (btrfsIoctlStater, error) {
+	if fixtures != "" {
+		return &mockBtrfsIoctlStater{
+			path: filepath.Join(fixtures, mountPoint, "filesystem.json"),
+		}, nil
+	}
+
+	fd, err := unix.Open(rootfsFilePath(mountPoint), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &btrfsIoctlFS{fd: fd}, nil
+}
+
+var _ btrfsIoctlStater = &btrfsIoctlFS{}
+
+type btrfsIoctlFS struct {
+	fd int
+}
+
+func (s *btrfsIoctlFS) ioctl(req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(s.fd), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *btrfsIoctlFS) Close() error { return unix.Close(s.fd) }
+
+func (s *btrfsIoctlFS) UUID() (string, error) {
+	var args btrfsIoctlFSInfoArgs
+	if err := s.ioctl(btrfsIocFSInfo, unsafe.Pointer(&args)); err != nil {
+		return "", err
+	}
+	id := args.fsid
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+func (s *btrfsIoctlFS) Devices() ([]btrfsIoctlDevice, error) {
+	var info btrfsIoctlFSInfoArgs
+	if err := s.ioctl(btrfsIocFSInfo, unsafe.Pointer(&info)); err != nil {
+		return nil, err
+	}
+
+	// Device IDs are not contiguous after devices have been removed.
+	var devices []btrfsIoctlDevice
+	for devid := uint64(1); devid <= info.maxID; devid++ {
+		args := btrfsIoctlDevInfoArgs{devid: devid}
+		err := s.ioctl(btrfsIocDevInfo, unsafe.Pointer(&args))
+		if errors.Is(err, unix.ENODEV) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, btrfsIoctlDevice{ID: devid, Path: bytesToString(args.path[:])})
+	}
+	return devices, nil
+}
+
+func (s *btrfsIoctlFS) DeviceErrors(devid uint64) (*btrfsDeviceErrors, error) {
+	args := btrfsIoctlGetDevStats{devid: devid, nrItems: uint64(len(btrfsIoctlGetDevStats{}.values))}
+	if err := s.ioctl(btrfsIocGetDevStats, unsafe.Pointer(&args)); err != nil {
+		return nil, err
+	}
+	v := args.values
+	return &btrfsDeviceErrors{Write: v[0], Read: v[1], Flush: v[2], Corruption: v[3], Generation: v[4]}, nil
+}
+
+func (s *btrfsIoctlFS) ScrubProgress(devid uint64) (*btrfsScrubProgress, error) {
+	args := btrfsIoctlScrubArgs{devid: devid}
+	err := s.ioctl(btrfsIocScrubProgress, unsafe.Pointer(&args))
+	if errors.Is(err, unix.ENOTCONN) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := args.progress
+	return &btrfsScrubProgress{
+		DataBytesScrubbed:   p[2],
+		TreeBytesScrubbed:   p[3],
+		ReadErrors:          p[4],
+		CsumErrors:          p[5],
+		VerifyErrors:        p[6],
+		SuperErrors:         p[9],
+		UncorrectableErrors: p[11],
+		CorrectedErrors:     p[12],
+	}, nil
+}
+
+func (s *btrfsIoctlFS) BalanceProgress() (*btrfsBalanceProgress, error) {
+	var args btrfsIoctlBalanceArgs
+	err := s.ioctl(btrfsIocBalanceProgress, unsafe.Pointer(&args))
+	if errors.Is(err, unix.ENOTCONN) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &btrfsBalanceProgress{
+		Running:    args.state&btrfsBalanceStateRunning != 0,
+		Expected:   args.stat[0],
+		Considered: args.stat[1],
+		Completed:  args.stat[2],
+	}, nil
+}
+
+var _ btrfsIoctlStater = &mockBtrfsIoctlStater{}
+
+type mockBtrfsIoctlStater struct {
+	path string
+}
+
+type mockBtrfsFilesystem struct {
+	UUID    string
+	Devices []struct {
+		btrfsIoctlDevice
+		Errors btrfsDeviceErrors
+		Scrub  *btrfsScrubProgress
+	}
+	Balance *btrfsBalanceProgress
+}
+
+func (s *mockBtrfsIoctlStater) filesystem() (*mockBtrfsFilesystem, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fs mockBtrfsFilesystem
+	if err := json.Unmarshal(b, &fs); err != nil {
+		return nil, err
+	}
+	return &fs, nil
+}
+
+func (s *mockBtrfsIoctlStater) Close() error { return nil }
+
+func (s *mockBtrfsIoctlStater) UUID() (string, error) {
+	fs, err := s.filesystem()
+	if err != nil {
+		return "", err
+	}
+	return fs.UUID, nil
+}
+
+func (s *mockBtrfsIoctlStater) Devices() ([]btrfsIoctlDevice, error) {
+	fs, err := s.filesystem()
+	if err != nil {
+		return nil, err
+	}
+	var devices []btrfsIoctlDevice
+	for _, d := range fs.Devices {
+		devices = append(devices, d.btrfsIoctlDevice)
+	}
+	return devices, nil
+}
+
+func (s *mockBtrfsIoctlStater) DeviceErrors(devid uint64) (*btrfsDeviceErrors, error) {
+	fs, err := s.filesystem()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range fs.Devices {
+		if d.ID == devid {
+			return &d.Errors, nil
+		}
+	}
+	return nil, unix.ENODEV
+}
+
+func (s *mockBtrfsIoctlStater) ScrubProgress(devid uint64) (*btrfsScrubProgress, error) {
+	fs, err := s.filesystem()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range fs.Devices {
+		if d.ID == devid {
+			return d.Scrub, nil
+		}
+	}
+	return nil, unix.ENODEV
+}
+
+func (s *mockBtrfsIoctlStater) BalanceProgress() (*btrfsBalanceProgress, error) {
+	fs, err := s.filesystem()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Balance, nil
+}
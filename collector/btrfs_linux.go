@@ -16,13 +16,22 @@
 package collector
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs/btrfs"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
+var btrfsIoctlFixtures = kingpin.Flag("collector.btrfs.ioctl-fixtures", "test fixtures to use for btrfs collector ioctl metrics").Default("").Hidden().String()
+
 // A btrfsCollector is a Collector which gathers metrics from Btrfs filesystems.
 type btrfsCollector struct {
 	fs     btrfs.FS
@@ -54,24 +63,128 @@ func (c *btrfsCollector) Update(ch chan<- prometheus.Metric) error {
 		return fmt.Errorf("failed to retrieve Btrfs stats: %w", err)
 	}
 
+	ioctlStats, err := c.getIoctlStats()
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs ioctl stats", "err", err)
+	}
+
 	for _, s := range stats {
-		c.updateBtrfsStats(ch, s)
+		c.updateBtrfsStats(ch, s, ioctlStats[s.UUID])
 	}
 
 	return nil
 }
 
+// btrfsIoctlStats are the statistics of a mounted Btrfs filesystem which are
+// only available through ioctls.
+type btrfsIoctlStats struct {
+	devices []btrfsIoctlDeviceStats
+	balance *btrfsBalanceProgress
+}
+
+type btrfsIoctlDeviceStats struct {
+	path   string
+	errors *btrfsDeviceErrors
+	scrub  *btrfsScrubProgress
+}
+
+// getIoctlStats returns the ioctl statistics of all mounted Btrfs filesystems
+// by UUID.
+func (c *btrfsCollector) getIoctlStats() (map[string]*btrfsIoctlStats, error) {
+	mountPoints, err := btrfsMountPoints(*btrfsIoctlFixtures)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[string]*btrfsIoctlStats{}
+	for _, mountPoint := range mountPoints {
+		fs, err := newBtrfsIoctlStater(*btrfsIoctlFixtures, mountPoint)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to open Btrfs filesystem", "mountpoint", mountPoint, "err", err)
+			continue
+		}
+		uuid, err := fs.UUID()
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs filesystem UUID", "mountpoint", mountPoint, "err", err)
+		} else if _, ok := stats[uuid]; !ok {
+			// Subvolumes of a filesystem may be mounted at several mount points.
+			stats[uuid] = c.getFilesystemIoctlStats(fs, mountPoint)
+		}
+		fs.Close()
+	}
+	return stats, nil
+}
+
+func (c *btrfsCollector) getFilesystemIoctlStats(fs btrfsIoctlStater, mountPoint string) *btrfsIoctlStats {
+	var stats btrfsIoctlStats
+
+	devices, err := fs.Devices()
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs devices", "mountpoint", mountPoint, "err", err)
+	}
+	for _, dev := range devices {
+		d := btrfsIoctlDeviceStats{path: dev.Path}
+		if d.errors, err = fs.DeviceErrors(dev.ID); err != nil {
+			level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs device errors", "device", dev.Path, "err", err)
+		}
+		// Scrub and balance progress require CAP_SYS_ADMIN.
+		if d.scrub, err = fs.ScrubProgress(dev.ID); err != nil {
+			level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs scrub progress", "device", dev.Path, "err", err)
+		}
+		stats.devices = append(stats.devices, d)
+	}
+
+	if stats.balance, err = fs.BalanceProgress(); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to retrieve Btrfs balance progress", "mountpoint", mountPoint, "err", err)
+	}
+	return &stats
+}
+
+// btrfsMountPoints returns the mount points of all Btrfs filesystems, or of
+// those in the mounts file of the fixtures.
+func btrfsMountPoints(fixtures string) ([]string, error) {
+	var (
+		file *os.File
+		err  error
+	)
+	if fixtures != "" {
+		file, err = os.Open(filepath.Join(fixtures, "mounts"))
+	} else {
+		file, err = os.Open(procFilePath("1/mounts"))
+	}
+	if errors.Is(err, os.ErrNotExist) && fixtures == "" {
+		// Fallback to `/proc/mounts` if `/proc/1/mounts` is missing due hidepid.
+		file, err = os.Open(procFilePath("mounts"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 4 || parts[2] != "btrfs" {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(parts[1]))
+	}
+	return mountPoints, scanner.Err()
+}
+
 // btrfsMetric represents a single Btrfs metric that is converted into a Prometheus Metric.
 type btrfsMetric struct {
 	name            string
 	desc            string
 	value           float64
+	counter         bool
 	extraLabel      []string
 	extraLabelValue []string
 }
 
 // updateBtrfsStats collects statistics for one bcache ID.
-func (c *btrfsCollector) updateBtrfsStats(ch chan<- prometheus.Metric, s *btrfs.Stats) {
+func (c *btrfsCollector) updateBtrfsStats(ch chan<- prometheus.Metric, s *btrfs.Stats, ioctlStats *btrfsIoctlStats) {
 	const subsystem = "btrfs"
 
 	// Basic information about the filesystem.
@@ -79,6 +192,9 @@ func (c *btrfsCollector) updateBtrfsStats(ch chan<- prometheus.Metric, s *btrfs.
 
 	// Retrieve the metrics.
 	metrics := c.getMetrics(s)
+	if ioctlStats != nil {
+		metrics = append(metrics, c.getIoctlMetrics(ioctlStats)...)
+	}
 
 	// Convert all gathered metrics to Prometheus Metrics and add to channel.
 	for _, m := range metrics {
@@ -96,9 +212,14 @@ func (c *btrfsCollector) updateBtrfsStats(ch chan<- prometheus.Metric, s *btrfs.
 			labelValues = append(labelValues, m.extraLabelValue...)
 		}
 
+		valueType := prometheus.GaugeValue
+		if m.counter {
+			valueType = prometheus.CounterValue
+		}
+
 		ch <- prometheus.MustNewConstMetric(
 			desc,
-			prometheus.GaugeValue,
+			valueType,
 			m.value,
 			labelValues...,
 		)
@@ -187,3 +308,90 @@ func (c *btrfsCollector) getLayoutStats(a, l string, s *btrfs.LayoutUsage) []btr
 		},
 	}
 }
+
+// getIoctlMetrics returns metrics for the given Btrfs ioctl statistics.
+func (c *btrfsCollector) getIoctlMetrics(s *btrfsIoctlStats) []btrfsMetric {
+	var metrics []btrfsMetric
+
+	for _, dev := range s.devices {
+		if dev.errors != nil {
+			for typ, v := range map[string]uint64{
+				"write":      dev.errors.Write,
+				"read":       dev.errors.Read,
+				"flush":      dev.errors.Flush,
+				"corruption": dev.errors.Corruption,
+				"generation": dev.errors.Generation,
+			} {
+				metrics = append(metrics, btrfsMetric{
+					name:            "device_errors_total",
+					desc:            "Errors reported for a device that is part of the filesystem.",
+					value:           float64(v),
+					counter:         true,
+					extraLabel:      []string{"device", "type"},
+					extraLabelValue: []string{dev.path, typ},
+				})
+			}
+		}
+
+		running := 0.0
+		if dev.scrub != nil {
+			running = 1
+			metrics = append(metrics, btrfsMetric{
+				name:            "scrub_scrubbed_bytes",
+				desc:            "Amount of data and metadata scrubbed by the running scrub of a device.",
+				value:           float64(dev.scrub.DataBytesScrubbed + dev.scrub.TreeBytesScrubbed),
+				extraLabel:      []string{"device"},
+				extraLabelValue: []string{dev.path},
+			})
+			for typ, v := range map[string]uint64{
+				"read":          dev.scrub.ReadErrors,
+				"csum":          dev.scrub.CsumErrors,
+				"verify":        dev.scrub.VerifyErrors,
+				"super":         dev.scrub.SuperErrors,
+				"uncorrectable": dev.scrub.UncorrectableErrors,
+				"corrected":     dev.scrub.CorrectedErrors,
+			} {
+				metrics = append(metrics, btrfsMetric{
+					name:            "scrub_errors",
+					desc:            "Errors found by the running scrub of a device.",
+					value:           float64(v),
+					extraLabel:      []string{"device", "type"},
+					extraLabelValue: []string{dev.path, typ},
+				})
+			}
+		}
+		metrics = append(metrics, btrfsMetric{
+			name:            "scrub_running",
+			desc:            "Whether a scrub of a device is running.",
+			value:           running,
+			extraLabel:      []string{"device"},
+			extraLabelValue: []string{dev.path},
+		})
+	}
+
+	var running, paused float64
+	if s.balance != nil {
+		if s.balance.Running {
+			running = 1
+		} else {
+			paused = 1
+		}
+		for _, m := range []struct {
+			name string
+			desc string
+			v    uint64
+		}{
+			{"balance_expected_chunks", "Number of chunks the running or paused balance is expected to relocate.", s.balance.Expected},
+			{"balance_considered_chunks", "Number of chunks considered by the running or paused balance.", s.balance.Considered},
+			{"balance_completed_chunks", "Number of chunks relocated by the running or paused balance.", s.balance.Completed},
+		} {
+			metrics = append(metrics, btrfsMetric{name: m.name, desc: m.desc, value: float64(m.v)})
+		}
+	}
+	metrics = append(metrics,
+		btrfsMetric{name: "balance_running", desc: "Whether a balance of the filesystem is running.", value: running},
+		btrfsMetric{name: "balance_paused", desc: "Whether a balance of the filesystem is paused.", value: paused},
+	)
+
+	return metrics
+}
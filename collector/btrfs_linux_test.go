@@ -16,10 +16,16 @@
 package collector
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"unsafe"
 
+	"github.com/go-kit/kit/log"
 	"github.com/prometheus/procfs/btrfs"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var expectedBtrfsMetrics = [][]btrfsMetric{
@@ -116,3 +122,82 @@ func TestBtrfs(t *testing.T) {
 		}
 	}
 }
+
+func TestBtrfsIoctlArgsSize(t *testing.T) {
+	for name, test := range map[string]struct {
+		got, want uintptr
+	}{
+		"btrfs_ioctl_fs_info_args":  {unsafe.Sizeof(btrfsIoctlFSInfoArgs{}), 1024},
+		"btrfs_ioctl_dev_info_args": {unsafe.Sizeof(btrfsIoctlDevInfoArgs{}), 4096},
+		"btrfs_ioctl_get_dev_stats": {unsafe.Sizeof(btrfsIoctlGetDevStats{}), 1032},
+		"btrfs_ioctl_scrub_args":    {unsafe.Sizeof(btrfsIoctlScrubArgs{}), 1024},
+		"btrfs_ioctl_balance_args":  {unsafe.Sizeof(btrfsIoctlBalanceArgs{}), 1024},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: want size %d, got %d", name, test.want, test.got)
+		}
+	}
+}
+
+func TestBtrfsIoctlStats(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.procfs", "fixtures/proc",
+		"--collector.btrfs.ioctl-fixtures", "fixtures/btrfs",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	collector := &btrfsCollector{logger: log.NewNopLogger()}
+
+	stats, err := collector.getIoctlStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("want stats of 2 filesystems, got %d", len(stats))
+	}
+
+	s, ok := stats["0abb23a9-579b-43e6-ad30-227ef47fcb9d"]
+	if !ok {
+		t.Fatal("missing stats of filesystem 0abb23a9-579b-43e6-ad30-227ef47fcb9d")
+	}
+	want := []string{
+		"balance_paused=0",
+		"balance_running=0",
+		"device_errors_total{/dev/loop25,corruption}=0",
+		"device_errors_total{/dev/loop25,flush}=0",
+		"device_errors_total{/dev/loop25,generation}=0",
+		"device_errors_total{/dev/loop25,read}=0",
+		"device_errors_total{/dev/loop25,write}=0",
+		"device_errors_total{/dev/loop26,corruption}=7",
+		"device_errors_total{/dev/loop26,flush}=1",
+		"device_errors_total{/dev/loop26,generation}=0",
+		"device_errors_total{/dev/loop26,read}=12",
+		"device_errors_total{/dev/loop26,write}=3",
+		"scrub_errors{/dev/loop26,corrected}=6",
+		"scrub_errors{/dev/loop26,csum}=5",
+		"scrub_errors{/dev/loop26,read}=2",
+		"scrub_errors{/dev/loop26,super}=0",
+		"scrub_errors{/dev/loop26,uncorrectable}=1",
+		"scrub_errors{/dev/loop26,verify}=0",
+		"scrub_running{/dev/loop25}=0",
+		"scrub_running{/dev/loop26}=1",
+		"scrub_scrubbed_bytes{/dev/loop26}=5.378048e+08",
+	}
+	var got []string
+	for _, m := range collector.getIoctlMetrics(s) {
+		name := m.name
+		if len(m.extraLabelValue) > 0 {
+			name += "{" + strings.Join(m.extraLabelValue, ",") + "}"
+		}
+		got = append(got, fmt.Sprintf("%s=%v", name, m.value))
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want metrics %v, got %v", want, got)
+	}
+
+	balance := stats["7f07c59f-6136-449c-ab87-e1cf2328731b"].balance
+	if want := (&btrfsBalanceProgress{Running: true, Expected: 12, Considered: 5, Completed: 4}); !reflect.DeepEqual(balance, want) {
+		t.Errorf("want balance %+v, got %+v", want, balance)
+	}
+}
@@ -55,6 +55,10 @@ var (
 	forcedCollectors = map[string]bool{} // collectors which have been explicitly enabled or disabled
 )
 
+// registerCollector registers the factory and the enable flag of a collector.
+// Factories are called again for every filtered scrape, so state that has to
+// outlive a collector instance, such as kernel subscriptions or counts built up
+// between scrapes, is kept in package variables set up once per process.
 func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger) (Collector, error)) {
 	var helpDefaultState string
 	if isDefaultEnabled {
@@ -16,14 +16,31 @@
 package collector
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
+var conntrackProtocolEntries = kingpin.Flag("collector.conntrack.protocol-entries", "Enables metric nf_conntrack_protocol_entries, which dumps the connection tracking table via netlink on every scrape.").Bool()
+
+// conntrackStatFields are the exported per CPU conntrack statistics, see
+// ct_cpu_seq_show() in the kernel.
+var conntrackStatFields = []string{"found", "invalid", "ignore", "insert", "insert_failed", "drop", "early_drop", "search_restart"}
+
 type conntrackCollector struct {
-	current *prometheus.Desc
-	limit   *prometheus.Desc
-	logger  log.Logger
+	current         *prometheus.Desc
+	limit           *prometheus.Desc
+	stats           map[string]*prometheus.Desc
+	protocolEntries *prometheus.Desc
+	logger          log.Logger
 }
 
 func init() {
@@ -32,6 +49,15 @@ func init() {
 
 // NewConntrackCollector returns a new Collector exposing conntrack stats.
 func NewConntrackCollector(logger log.Logger) (Collector, error) {
+	stats := make(map[string]*prometheus.Desc, len(conntrackStatFields))
+	for _, field := range conntrackStatFields {
+		stats[field] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "nf_conntrack_stat", field+"_total"),
+			fmt.Sprintf("Connection tracking %s events, summed over all CPUs.", strings.Replace(field, "_", " ", -1)),
+			nil, nil,
+		)
+	}
+
 	return &conntrackCollector{
 		current: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "nf_conntrack_entries"),
@@ -43,6 +69,12 @@ func NewConntrackCollector(logger log.Logger) (Collector, error) {
 			"Maximum size of connection tracking table.",
 			nil, nil,
 		),
+		stats: stats,
+		protocolEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "nf_conntrack_protocol_entries"),
+			"Number of flow entries for connection tracking by zone, protocol and TCP state.",
+			[]string{"zone", "protocol", "state"}, nil,
+		),
 		logger: logger,
 	}, nil
 }
@@ -63,5 +95,70 @@ func (c *conntrackCollector) Update(ch chan<- prometheus.Metric) error {
 	ch <- prometheus.MustNewConstMetric(
 		c.limit, prometheus.GaugeValue, float64(value))
 
+	if stats, err := readConntrackStats(procFilePath("net/stat/nf_conntrack")); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to read conntrack statistics", "err", err)
+	} else {
+		for _, field := range conntrackStatFields {
+			if v, ok := stats[field]; ok {
+				ch <- prometheus.MustNewConstMetric(c.stats[field], prometheus.CounterValue, float64(v))
+			}
+		}
+	}
+
+	if !*conntrackProtocolEntries {
+		return nil
+	}
+	entries, err := dumpConntrackEntries()
+	if err != nil {
+		return fmt.Errorf("failed to dump conntrack table: %w", err)
+	}
+	for k, v := range entries {
+		ch <- prometheus.MustNewConstMetric(c.protocolEntries, prometheus.GaugeValue, float64(v),
+			strconv.Itoa(int(k.zone)), k.protocol, k.state)
+	}
 	return nil
 }
+
+func readConntrackStats(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseConntrackStats(file)
+}
+
+// parseConntrackStats parses /proc/net/stat/nf_conntrack, which has a header
+// line followed by a line of hexadecimal counters per CPU, and returns the
+// counters summed over all CPUs.
+func parseConntrackStats(r io.Reader) (map[string]uint64, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing header")
+	}
+	header := strings.Fields(scanner.Text())
+
+	stats := map[string]uint64{}
+	for scanner.Scan() {
+		values := strings.Fields(scanner.Text())
+		if len(values) != len(header) {
+			return nil, fmt.Errorf("invalid line %q, want %d fields", scanner.Text(), len(header))
+		}
+		for i, field := range header {
+			// entries is the number of entries of all CPUs.
+			if field == "entries" {
+				continue
+			}
+			v, err := strconv.ParseUint(values[i], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", field, err)
+			}
+			stats[field] += v
+		}
+	}
+	return stats, scanner.Err()
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noconntrack
+
+package collector
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadConntrackStats(t *testing.T) {
+	stats, err := readConntrackStats("fixtures/proc/net/stat/nf_conntrack")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for field, want := range map[string]uint64{
+		"invalid":        5,
+		"ignore":         0x588a + 0x56a4,
+		"insert_failed":  2,
+		"drop":           1,
+		"early_drop":     0,
+		"search_restart": 4,
+	} {
+		if got := stats[field]; got != want {
+			t.Errorf("%s: want %d, got %d", field, want, got)
+		}
+	}
+	if _, ok := stats["entries"]; ok {
+		t.Error("entries should not be summed over CPUs")
+	}
+}
+
+func conntrackMessage(t *testing.T, protocol uint8, tcpState int, zone uint16) netlink.Message {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTupleOrig, func(nae *netlink.AttributeEncoder) error {
+		nae.Nested(ctaTupleProto, func(pae *netlink.AttributeEncoder) error {
+			pae.Uint8(ctaProtoNum, protocol)
+			return nil
+		})
+		return nil
+	})
+	if tcpState >= 0 {
+		ae.Nested(ctaProtoInfo, func(nae *netlink.AttributeEncoder) error {
+			nae.Nested(ctaProtoInfoTCP, func(tae *netlink.AttributeEncoder) error {
+				tae.Uint8(ctaProtoInfoTCPState, uint8(tcpState))
+				return nil
+			})
+			return nil
+		})
+	}
+	if zone != 0 {
+		ae.Uint16(ctaZone, zone)
+	}
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return netlink.Message{Data: append([]byte{unix.AF_INET, 0, 0, 0}, b...)}
+}
+
+func TestParseConntrackEntries(t *testing.T) {
+	entries, err := parseConntrackEntries([]netlink.Message{
+		conntrackMessage(t, unix.IPPROTO_TCP, 3, 0),
+		conntrackMessage(t, unix.IPPROTO_TCP, 3, 0),
+		conntrackMessage(t, unix.IPPROTO_TCP, 7, 0),
+		conntrackMessage(t, unix.IPPROTO_TCP, 3, 2),
+		conntrackMessage(t, unix.IPPROTO_UDP, -1, 0),
+		conntrackMessage(t, 253, -1, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[conntrackEntryKey]uint64{
+		{0, "tcp", "established"}: 2,
+		{0, "tcp", "time_wait"}:   1,
+		{2, "tcp", "established"}: 1,
+		{0, "udp", ""}:            1,
+		{0, "253", ""}:            1,
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("want %v, got %v", want, entries)
+	}
+}
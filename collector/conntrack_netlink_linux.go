@@ -0,0 +1,158 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noconntrack
+
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ctnetlink message types and attributes, see
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCTNetlink = 1
+	ipctnlMsgCTGet      = 1
+
+	ctaTupleOrig = 1
+	ctaProtoInfo = 4
+	ctaZone      = 18
+
+	ctaTupleProto = 2
+	ctaProtoNum   = 1
+
+	ctaProtoInfoTCP      = 1
+	ctaProtoInfoTCPState = 1
+)
+
+var conntrackProtocols = map[uint8]string{
+	unix.IPPROTO_ICMP:    "icmp",
+	unix.IPPROTO_TCP:     "tcp",
+	unix.IPPROTO_UDP:     "udp",
+	unix.IPPROTO_DCCP:    "dccp",
+	unix.IPPROTO_GRE:     "gre",
+	unix.IPPROTO_ICMPV6:  "icmpv6",
+	unix.IPPROTO_SCTP:    "sctp",
+	unix.IPPROTO_UDPLITE: "udplite",
+}
+
+// conntrackTCPStates are the names of enum tcp_conntrack values.
+var conntrackTCPStates = []string{"none", "syn_sent", "syn_recv", "established", "fin_wait", "close_wait", "last_ack", "time_wait", "close", "syn_sent2"}
+
+type conntrackEntryKey struct {
+	zone     uint16
+	protocol string
+	state    string
+}
+
+// dumpConntrackEntries counts the entries of the connection tracking table
+// of all address families.
+func dumpConntrackEntries() (map[conntrackEntryKey]uint64, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysCTNetlink<<8 | ipctnlMsgCTGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{unix.AF_UNSPEC, 0, 0, 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseConntrackEntries(msgs)
+}
+
+func parseConntrackEntries(msgs []netlink.Message) (map[conntrackEntryKey]uint64, error) {
+	entries := map[conntrackEntryKey]uint64{}
+	for _, m := range msgs {
+		if len(m.Data) < nfgenmsgLen {
+			return nil, fmt.Errorf("short conntrack message of %d bytes", len(m.Data))
+		}
+		ad, err := netlink.NewAttributeDecoder(m.Data[nfgenmsgLen:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+
+		var (
+			key      conntrackEntryKey
+			protocol uint8
+			tcpState = -1
+		)
+		for ad.Next() {
+			switch ad.Type() {
+			case ctaTupleOrig:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					for nad.Next() {
+						if nad.Type() == ctaTupleProto {
+							nad.Nested(func(pad *netlink.AttributeDecoder) error {
+								for pad.Next() {
+									if pad.Type() == ctaProtoNum {
+										protocol = pad.Uint8()
+									}
+								}
+								return nil
+							})
+						}
+					}
+					return nil
+				})
+			case ctaProtoInfo:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					for nad.Next() {
+						if nad.Type() == ctaProtoInfoTCP {
+							nad.Nested(func(tad *netlink.AttributeDecoder) error {
+								for tad.Next() {
+									if tad.Type() == ctaProtoInfoTCPState {
+										tcpState = int(tad.Uint8())
+									}
+								}
+								return nil
+							})
+						}
+					}
+					return nil
+				})
+			case ctaZone:
+				key.zone = ad.Uint16()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+
+		key.protocol = conntrackProtocols[protocol]
+		if key.protocol == "" {
+			key.protocol = strconv.Itoa(int(protocol))
+		}
+		if tcpState >= 0 {
+			key.state = strconv.Itoa(tcpState)
+			if tcpState < len(conntrackTCPStates) {
+				key.state = conntrackTCPStates[tcpState]
+			}
+		}
+		entries[key]++
+	}
+	return entries, nil
+}
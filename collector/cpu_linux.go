@@ -17,8 +17,10 @@ package collector
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/go-kit/kit/log"
@@ -35,13 +37,17 @@ type cpuCollector struct {
 	cpuGuest           *prometheus.Desc
 	cpuCoreThrottle    *prometheus.Desc
 	cpuPackageThrottle *prometheus.Desc
+	cpuMicrocode       *prometheus.Desc
+	cpuVulnerability   *prometheus.Desc
+	cpuIsolated        *prometheus.Desc
+	cpuNohzFull        *prometheus.Desc
 	logger             log.Logger
 	cpuStats           []procfs.CPUStat
 	cpuStatsMutex      sync.Mutex
 }
 
 var (
-	enableCPUInfo = kingpin.Flag("collector.cpu.info", "Enables metric cpu_info").Bool()
+	enableCPUInfo = kingpin.Flag("collector.cpu.info", "Enables metrics cpu_info and cpu_microcode_revision").Bool()
 )
 
 func init() {
@@ -77,6 +83,26 @@ func NewCPUCollector(logger log.Logger) (Collector, error) {
 			"Number of times this cpu package has been throttled.",
 			[]string{"package"}, nil,
 		),
+		cpuMicrocode: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cpuCollectorSubsystem, "microcode_revision"),
+			"Loaded microcode revision of this cpu package from /proc/cpuinfo.",
+			[]string{"package"}, nil,
+		),
+		cpuVulnerability: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cpuCollectorSubsystem, "vulnerability_info"),
+			"Mitigation state of cpu vulnerabilities from /sys/devices/system/cpu/vulnerabilities.",
+			[]string{"vulnerability", "state", "details"}, nil,
+		),
+		cpuIsolated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cpuCollectorSubsystem, "isolated_info"),
+			"A metric with a constant '1' value labeled by the list of cpus isolated from the general scheduler with isolcpus.",
+			[]string{"cpus"}, nil,
+		),
+		cpuNohzFull: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cpuCollectorSubsystem, "nohz_full_info"),
+			"A metric with a constant '1' value labeled by the list of cpus running in adaptive-tick (nohz_full) mode.",
+			[]string{"cpus"}, nil,
+		),
 		logger: logger,
 	}, nil
 }
@@ -94,6 +120,12 @@ func (c *cpuCollector) Update(ch chan<- prometheus.Metric) error {
 	if err := c.updateThermalThrottle(ch); err != nil {
 		return err
 	}
+	if err := c.updateVulnerabilities(ch); err != nil {
+		return err
+	}
+	if err := c.updateIsolation(ch); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -118,6 +150,113 @@ func (c *cpuCollector) updateInfo(ch chan<- prometheus.Metric) error {
 			cpu.Stepping,
 			cpu.CacheSize)
 	}
+
+	// All cpus of a package are updated together, so the first cpu of each
+	// package is representative.
+	microcode := make(map[string]bool)
+	for _, cpu := range info {
+		if cpu.Microcode == "" || microcode[cpu.PhysicalID] {
+			continue
+		}
+		microcode[cpu.PhysicalID] = true
+		revision, err := strconv.ParseUint(strings.TrimPrefix(cpu.Microcode, "0x"), 16, 64)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "invalid microcode revision", "cpu", cpu.Processor, "microcode", cpu.Microcode)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.cpuMicrocode, prometheus.GaugeValue, float64(revision), cpu.PhysicalID)
+	}
+	return nil
+}
+
+// updateVulnerabilities reads /sys/devices/system/cpu/vulnerabilities/*.
+func (c *cpuCollector) updateVulnerabilities(ch chan<- prometheus.Metric) error {
+	files, err := filepath.Glob(sysFilePath("devices/system/cpu/vulnerabilities/*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read cpu vulnerability", "file", file, "err", err)
+			continue
+		}
+		state, details := parseCPUVulnerability(strings.TrimSpace(string(data)))
+		ch <- prometheus.MustNewConstMetric(c.cpuVulnerability, prometheus.GaugeValue, 1, filepath.Base(file), state, details)
+	}
+	return nil
+}
+
+// parseCPUVulnerability splits the content of a vulnerabilities file, e.g.
+// "Mitigation: PTI" or "Vulnerable, IBPB: disabled, STIBP: disabled", into a
+// state and its details.
+func parseCPUVulnerability(s string) (string, string) {
+	for _, state := range []struct {
+		prefix string
+		name   string
+	}{
+		{"Not affected", "not_affected"},
+		{"Vulnerable", "vulnerable"},
+		{"Mitigation", "mitigation"},
+	} {
+		if strings.HasPrefix(s, state.prefix) {
+			return state.name, strings.TrimLeft(strings.TrimPrefix(s, state.prefix), ":, ")
+		}
+	}
+	return "unknown", s
+}
+
+// updateIsolation reads the isolated and nohz_full cpu lists from
+// /sys/devices/system/cpu.
+func (c *cpuCollector) updateIsolation(ch chan<- prometheus.Metric) error {
+	for _, list := range []struct {
+		file string
+		desc *prometheus.Desc
+	}{
+		{"isolated", c.cpuIsolated},
+		{"nohz_full", c.cpuNohzFull},
+	} {
+		data, err := ioutil.ReadFile(sysFilePath(filepath.Join("devices/system/cpu", list.file)))
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read cpu list", "file", list.file, "err", err)
+			continue
+		}
+		cpus := strings.TrimSpace(string(data))
+		// The kernel writes "(null)" for unset nohz_full lists.
+		if cpus == "(null)" {
+			cpus = ""
+		}
+		if err := checkCPUList(cpus); err != nil {
+			level.Debug(c.logger).Log("msg", "invalid cpu list", "file", list.file, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(list.desc, prometheus.GaugeValue, 1, cpus)
+	}
+	return nil
+}
+
+// checkCPUList checks that a cpu list is in the form used by sysfs, such as
+// "0-3,8,10-11".
+func checkCPUList(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return err
+		}
+		if len(bounds) == 2 {
+			end, err := strconv.Atoi(bounds[1])
+			if err != nil {
+				return err
+			}
+			if end < start {
+				return fmt.Errorf("invalid cpu range %q", r)
+			}
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,65 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nocpu
+
+package collector
+
+import (
+	"testing"
+)
+
+func TestCheckCPUList(t *testing.T) {
+	tests := []struct {
+		list string
+		err  bool
+	}{
+		{list: ""},
+		{list: "3"},
+		{list: "0-2,5,7-8"},
+		{list: "1-a", err: true},
+		{list: "3-1", err: true},
+		{list: "(null)", err: true},
+	}
+
+	for _, test := range tests {
+		err := checkCPUList(test.list)
+		if test.err && err == nil {
+			t.Errorf("%q: expected error, got none", test.list)
+		}
+		if !test.err && err != nil {
+			t.Errorf("%q: unexpected error: %s", test.list, err)
+		}
+	}
+}
+
+func TestParseCPUVulnerability(t *testing.T) {
+	tests := []struct {
+		in      string
+		state   string
+		details string
+	}{
+		{"Not affected", "not_affected", ""},
+		{"Mitigation: PTI", "mitigation", "PTI"},
+		{"Vulnerable", "vulnerable", ""},
+		{"Vulnerable, IBPB: disabled, STIBP: disabled", "vulnerable", "IBPB: disabled, STIBP: disabled"},
+		{"Processor vulnerable", "unknown", "Processor vulnerable"},
+	}
+
+	for _, test := range tests {
+		state, details := parseCPUVulnerability(test.in)
+		if state != test.state || details != test.details {
+			t.Errorf("%q: want %q/%q, got %q/%q", test.in, test.state, test.details, state, details)
+		}
+	}
+}
@@ -31,6 +31,7 @@ type cpuFreqCollector struct {
 	scalingFreq    *prometheus.Desc
 	scalingFreqMin *prometheus.Desc
 	scalingFreqMax *prometheus.Desc
+	scalingInfo    *prometheus.Desc
 	logger         log.Logger
 }
 
@@ -77,6 +78,11 @@ func NewCPUFreqCollector(logger log.Logger) (Collector, error) {
 			"Maximum scaled cpu thread frequency in hertz.",
 			[]string{"cpu"}, nil,
 		),
+		scalingInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, cpuCollectorSubsystem, "scaling_info"),
+			"Frequency scaling driver and governor of the cpu thread.",
+			[]string{"cpu", "driver", "governor"}, nil,
+		),
 		logger: logger,
 	}, nil
 }
@@ -139,6 +145,16 @@ func (c *cpuFreqCollector) Update(ch chan<- prometheus.Metric) error {
 				stats.Name,
 			)
 		}
+		if stats.Driver != "" || stats.Governor != "" {
+			ch <- prometheus.MustNewConstMetric(
+				c.scalingInfo,
+				prometheus.GaugeValue,
+				1,
+				stats.Name,
+				stats.Driver,
+				stats.Governor,
+			)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,211 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nodevmapper
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const devmapperSubsystem = "devmapper"
+
+// scsiDeviceStates are the states of a SCSI device in sysfs, see
+// scsi_device_state in the kernel.
+var scsiDeviceStates = []string{"blocked", "cancel", "created", "created-blocked", "del", "offline", "quiesce", "running", "transport-offline"}
+
+type devmapperCollector struct {
+	info      *prometheus.Desc
+	suspended *prometheus.Desc
+	slave     *prometheus.Desc
+	pathState *prometheus.Desc
+	logger    log.Logger
+}
+
+func init() {
+	registerCollector(devmapperSubsystem, defaultDisabled, NewDevmapperCollector)
+}
+
+// NewDevmapperCollector returns a new Collector exposing the topology of
+// device-mapper devices, LVM logical volumes and multipath devices.
+func NewDevmapperCollector(logger log.Logger) (Collector, error) {
+	return &devmapperCollector{
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, devmapperSubsystem, "device_info"),
+			"A metric with a constant '1' value mapping a device-mapper device to its name, UUID, LVM volume and multipath device.",
+			[]string{"device", "name", "uuid", "vg", "lv", "layer", "mpath"}, nil,
+		),
+		suspended: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, devmapperSubsystem, "device_suspended"),
+			"Whether the device-mapper device is suspended.",
+			[]string{"device"}, nil,
+		),
+		slave: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, devmapperSubsystem, "slave_info"),
+			"A metric with a constant '1' value for each underlying device of a device-mapper device.",
+			[]string{"device", "slave"}, nil,
+		),
+		pathState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, devmapperSubsystem, "multipath_path_state"),
+			"Whether the SCSI device of a multipath path is in the given state.",
+			[]string{"device", "mpath", "path", "state"}, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+func (c *devmapperCollector) Update(ch chan<- prometheus.Metric) error {
+	devices, err := filepath.Glob(sysFilePath("block/dm-*"))
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		level.Debug(c.logger).Log("msg", "no device-mapper devices found")
+		return ErrNoData
+	}
+
+	for _, path := range devices {
+		device := filepath.Base(path)
+		dm, err := readDevmapperDevice(path)
+		if err != nil {
+			// Devices may be removed at any time.
+			level.Debug(c.logger).Log("msg", "failed to read device-mapper device", "device", device, "err", err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1,
+			device, dm.name, dm.uuid, dm.vg, dm.lv, dm.layer, dm.mpath)
+		ch <- prometheus.MustNewConstMetric(c.suspended, prometheus.GaugeValue, dm.suspended, device)
+		for _, slave := range dm.slaves {
+			ch <- prometheus.MustNewConstMetric(c.slave, prometheus.GaugeValue, 1, device, slave)
+		}
+
+		if dm.mpath == "" {
+			continue
+		}
+		for _, slave := range dm.slaves {
+			state, err := ioutil.ReadFile(sysFilePath(filepath.Join("block", slave, "device/state")))
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to read multipath path state", "device", device, "path", slave, "err", err)
+				continue
+			}
+			for _, s := range devmapperPathStates(strings.TrimSpace(string(state))) {
+				ch <- prometheus.MustNewConstMetric(c.pathState, prometheus.GaugeValue, s.value, device, dm.mpath, slave, s.state)
+			}
+		}
+	}
+	return nil
+}
+
+type devmapperDevice struct {
+	name, uuid    string
+	vg, lv, layer string
+	mpath         string
+	suspended     float64
+	slaves        []string
+}
+
+// readDevmapperDevice reads a device-mapper device from its directory in
+// /sys/block.
+func readDevmapperDevice(path string) (devmapperDevice, error) {
+	var dm devmapperDevice
+	for file, v := range map[string]*string{"name": &dm.name, "uuid": &dm.uuid} {
+		data, err := ioutil.ReadFile(filepath.Join(path, "dm", file))
+		if err != nil {
+			return dm, err
+		}
+		*v = strings.TrimSpace(string(data))
+	}
+	if suspended, err := readUintFromFile(filepath.Join(path, "dm/suspended")); err == nil {
+		dm.suspended = float64(suspended)
+	}
+
+	slaves, err := ioutil.ReadDir(filepath.Join(path, "slaves"))
+	if err != nil && !os.IsNotExist(err) {
+		return dm, err
+	}
+	for _, slave := range slaves {
+		dm.slaves = append(dm.slaves, slave.Name())
+	}
+
+	// The UUID prefix identifies the subsystem which created the device.
+	switch {
+	case strings.HasPrefix(dm.uuid, "LVM-"):
+		dm.vg, dm.lv, dm.layer = parseLVMName(dm.name)
+	case strings.HasPrefix(dm.uuid, "mpath-"):
+		dm.mpath = dm.name
+	}
+	return dm, nil
+}
+
+// parseLVMName splits the device-mapper name of an LVM logical volume into
+// its volume group, logical volume and layer, see dm_split_lvm_name() in
+// lvm2. Hyphens within the names are escaped by doubling them.
+func parseLVMName(name string) (vg, lv, layer string) {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '-' {
+			if i+1 < len(name) && name[i+1] == '-' {
+				b.WriteByte('-')
+				i++
+				continue
+			}
+			parts = append(parts, b.String())
+			b.Reset()
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	parts = append(parts, b.String())
+
+	switch len(parts) {
+	case 1:
+		return parts[0], "", ""
+	case 2:
+		return parts[0], parts[1], ""
+	}
+	return parts[0], parts[1], strings.Join(parts[2:], "-")
+}
+
+type devmapperPathState struct {
+	state string
+	value float64
+}
+
+// devmapperPathStates returns all known SCSI device states, with the current
+// state set.
+func devmapperPathStates(current string) []devmapperPathState {
+	states := make([]devmapperPathState, 0, len(scsiDeviceStates)+1)
+	known := false
+	for _, state := range scsiDeviceStates {
+		s := devmapperPathState{state: state}
+		if state == current {
+			s.value = 1
+			known = true
+		}
+		states = append(states, s)
+	}
+	if !known {
+		states = append(states, devmapperPathState{state: current, value: 1})
+	}
+	return states
+}
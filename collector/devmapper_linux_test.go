@@ -0,0 +1,54 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nodevmapper
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLVMName(t *testing.T) {
+	tests := []struct {
+		name, vg, lv, layer string
+	}{
+		{name: "vg0-root", vg: "vg0", lv: "root"},
+		{name: "vg0-my--data-real", vg: "vg0", lv: "my-data", layer: "real"},
+		{name: "my--vg-thin--pool-tpool", vg: "my-vg", lv: "thin-pool", layer: "tpool"},
+		{name: "vg0", vg: "vg0"},
+	}
+	for _, test := range tests {
+		vg, lv, layer := parseLVMName(test.name)
+		if vg != test.vg || lv != test.lv || layer != test.layer {
+			t.Errorf("%s: want %q/%q/%q, got %q/%q/%q", test.name, test.vg, test.lv, test.layer, vg, lv, layer)
+		}
+	}
+}
+
+func TestReadDevmapperDevice(t *testing.T) {
+	dm, err := readDevmapperDevice("fixtures/sys/block/dm-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := devmapperDevice{
+		name:   "mpatha",
+		uuid:   "mpath-3600508b400105e210000900000490000",
+		mpath:  "mpatha",
+		slaves: []string{"sdb", "sdc"},
+	}
+	if !reflect.DeepEqual(dm, want) {
+		t.Errorf("want %+v, got %+v", want, dm)
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -37,6 +39,7 @@ const (
 
 var (
 	ignoredDevices = kingpin.Flag("collector.diskstats.ignored-devices", "Regexp of devices to ignore for diskstats.").Default("^(ram|loop|fd|(h|s|v|xv)d[a-z]|nvme\\d+n\\d+p)\\d+$").String()
+	diskstatsSysfs = kingpin.Flag("collector.diskstats.sysfs", "Read device statistics from /sys/block/<dev>/stat instead of /proc/diskstats, e.g. when /proc/diskstats is not namespaced correctly in containers.").Bool()
 )
 
 type typedFactorDesc struct {
@@ -55,9 +58,17 @@ func (d *typedFactorDesc) mustNewConstMetric(value float64, labels ...string) pr
 type diskstatsCollector struct {
 	ignoredDevicesPattern *regexp.Regexp
 	descs                 []typedFactorDesc
+	queueInfoDesc         *prometheus.Desc
+	queueDescs            []diskQueueDesc
 	logger                log.Logger
 }
 
+// diskQueueDesc is a numeric queue setting from /sys/block/<dev>/queue.
+type diskQueueDesc struct {
+	file string
+	desc *prometheus.Desc
+}
+
 func init() {
 	registerCollector("diskstats", defaultEnabled, NewDiskstatsCollector)
 }
@@ -180,12 +191,60 @@ func NewDiskstatsCollector(logger log.Logger) (Collector, error) {
 				factor: .001,
 			},
 		},
+		queueInfoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, diskSubsystem, "queue_info"),
+			"A metric with a constant '1' value labeled by the active I/O scheduler and write cache mode of the device queue.",
+			[]string{"device", "scheduler", "write_cache"},
+			nil,
+		),
+		queueDescs: []diskQueueDesc{
+			{
+				file: "rotational",
+				desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, diskSubsystem, "queue_rotational"),
+					"Whether the device is of rotational type.",
+					diskLabelNames,
+					nil,
+				),
+			},
+			{
+				file: "nr_requests",
+				desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, diskSubsystem, "queue_nr_requests"),
+					"The maximum number of read and write requests that can be allocated in the block layer.",
+					diskLabelNames,
+					nil,
+				),
+			},
+			{
+				file: "logical_block_size",
+				desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, diskSubsystem, "queue_logical_block_size_bytes"),
+					"The logical block size of the device in bytes.",
+					diskLabelNames,
+					nil,
+				),
+			},
+			{
+				file: "discard_max_bytes",
+				desc: prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, diskSubsystem, "queue_discard_max_bytes"),
+					"The maximum number of bytes discarded in a single operation, zero if the device does not support discard.",
+					diskLabelNames,
+					nil,
+				),
+			},
+		},
 		logger: logger,
 	}, nil
 }
 
 func (c *diskstatsCollector) Update(ch chan<- prometheus.Metric) error {
-	diskStats, err := getDiskStats()
+	getStats := getDiskStats
+	if *diskstatsSysfs {
+		getStats = getSysfsDiskStats
+	}
+	diskStats, err := getStats()
 	if err != nil {
 		return fmt.Errorf("couldn't get diskstats: %w", err)
 	}
@@ -207,10 +266,60 @@ func (c *diskstatsCollector) Update(ch chan<- prometheus.Metric) error {
 			}
 			ch <- c.descs[i].mustNewConstMetric(v, dev)
 		}
+		c.updateQueue(ch, dev)
 	}
 	return nil
 }
 
+// updateQueue exposes the queue settings of a device. Partitions have no
+// queue of their own.
+func (c *diskstatsCollector) updateQueue(ch chan<- prometheus.Metric, dev string) {
+	dir := sysFilePath(filepath.Join("block", diskSysfsName(dev), "queue"))
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+
+	for _, d := range c.queueDescs {
+		v, err := readUintFromFile(filepath.Join(dir, d.file))
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read queue setting", "device", dev, "file", d.file, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, float64(v), dev)
+	}
+
+	var scheduler, writeCache string
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "scheduler")); err == nil {
+		scheduler = parseDiskScheduler(string(data))
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "write_cache")); err == nil {
+		writeCache = strings.TrimSpace(string(data))
+	}
+	ch <- prometheus.MustNewConstMetric(c.queueInfoDesc, prometheus.GaugeValue, 1, dev, scheduler, writeCache)
+}
+
+// parseDiskScheduler returns the active scheduler from the list of available
+// ones, e.g. "mq-deadline kyber [bfq] none".
+func parseDiskScheduler(s string) string {
+	schedulers := strings.Fields(s)
+	for _, scheduler := range schedulers {
+		if strings.HasPrefix(scheduler, "[") && strings.HasSuffix(scheduler, "]") {
+			return strings.Trim(scheduler, "[]")
+		}
+	}
+	// Devices without a choice only list the scheduler in use.
+	if len(schedulers) == 1 {
+		return schedulers[0]
+	}
+	return ""
+}
+
+// diskSysfsName returns the name of a device in sysfs, where slashes are
+// replaced by exclamation marks, e.g. cciss!c0d0.
+func diskSysfsName(dev string) string {
+	return strings.Replace(dev, "/", "!", -1)
+}
+
 func getDiskStats() (map[string][]string, error) {
 	file, err := os.Open(procFilePath(diskstatsFilename))
 	if err != nil {
@@ -238,3 +347,34 @@ func parseDiskStats(r io.Reader) (map[string][]string, error) {
 
 	return diskStats, scanner.Err()
 }
+
+// getSysfsDiskStats reads the statistics of devices and their partitions from
+// /sys/block/<dev>/stat, which have the same fields as /proc/diskstats.
+func getSysfsDiskStats() (map[string][]string, error) {
+	disks, err := filepath.Glob(sysFilePath("block/*/stat"))
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := filepath.Glob(sysFilePath("block/*/*/partition"))
+	if err != nil {
+		return nil, err
+	}
+	for _, partition := range partitions {
+		disks = append(disks, filepath.Join(filepath.Dir(partition), "stat"))
+	}
+
+	diskStats := map[string][]string{}
+	for _, path := range disks {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			// Devices may be removed at any time.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		dev := strings.Replace(filepath.Base(filepath.Dir(path)), "!", "/", -1)
+		diskStats[dev] = strings.Fields(string(data))
+	}
+	return diskStats, nil
+}
@@ -15,7 +15,10 @@ package collector
 
 import (
 	"os"
+	"reflect"
 	"testing"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 func TestDiskStats(t *testing.T) {
@@ -50,3 +53,42 @@ func TestDiskStats(t *testing.T) {
 		t.Errorf("want diskstats sdc %s, got %s", want, got)
 	}
 }
+
+func TestSysfsDiskStats(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", "fixtures/sys"}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open("fixtures/proc/diskstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	procStats, err := parseDiskStats(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sysfsStats, err := getSysfsDiskStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dev := range []string{"sdb", "sdb1"} {
+		if !reflect.DeepEqual(sysfsStats[dev], procStats[dev]) {
+			t.Errorf("want %s stats %v, got %v", dev, procStats[dev], sysfsStats[dev])
+		}
+	}
+}
+
+func TestParseDiskScheduler(t *testing.T) {
+	for s, want := range map[string]string{
+		"mq-deadline kyber [bfq] none\n": "bfq",
+		"[none] mq-deadline":             "none",
+		"none\n":                         "none",
+		"noop deadline cfq":              "",
+	} {
+		if got := parseDiskScheduler(s); got != want {
+			t.Errorf("%q: want %q, got %q", s, want, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nodmi
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	dmiRedactSerials = kingpin.Flag("collector.dmi.redact-serials", "Do not expose system, board and chassis serial numbers in dmi_info, use --no-collector.dmi.redact-serials to expose them.").Default("true").Bool()
+
+	// dmiLabels are the labels of dmi_info, exported from the file of the
+	// same name in /sys/class/dmi/id unless listed in dmiFiles.
+	dmiLabels = []string{
+		"bios_date",
+		"bios_vendor",
+		"bios_version",
+		"board_name",
+		"board_serial",
+		"board_vendor",
+		"board_version",
+		"chassis_asset_tag",
+		"chassis_serial",
+		"chassis_vendor",
+		"chassis_version",
+		"product_family",
+		"product_name",
+		"product_serial",
+		"product_sku",
+		"product_version",
+		"system_vendor",
+	}
+	dmiFiles = map[string]string{
+		"system_vendor": "sys_vendor",
+	}
+	// dmiSerials are the labels redacted by --collector.dmi.redact-serials.
+	dmiSerials = map[string]bool{
+		"board_serial":      true,
+		"chassis_asset_tag": true,
+		"chassis_serial":    true,
+		"product_serial":    true,
+	}
+)
+
+type dmiCollector struct {
+	info   *prometheus.Desc
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("dmi", defaultEnabled, NewDMICollector)
+}
+
+// NewDMICollector returns a new Collector exposing DMI hardware information.
+func NewDMICollector(logger log.Logger) (Collector, error) {
+	return &dmiCollector{
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dmi", "info"),
+			"A metric with a constant '1' value labeled by bios, board, chassis and system information from /sys/class/dmi/id.",
+			dmiLabels, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+func (c *dmiCollector) Update(ch chan<- prometheus.Metric) error {
+	dir := sysFilePath("class/dmi/id")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		level.Debug(c.logger).Log("msg", "DMI information is unavailable", "path", dir)
+		return ErrNoData
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, readDMIInfo(dir, *dmiRedactSerials, c.logger)...)
+	return nil
+}
+
+// readDMIInfo returns the values of dmiLabels read from dir, leaving those
+// that are unreadable or redacted empty.
+func readDMIInfo(dir string, redactSerials bool, logger log.Logger) []string {
+	values := make([]string, len(dmiLabels))
+	for i, label := range dmiLabels {
+		if redactSerials && dmiSerials[label] {
+			continue
+		}
+		file, ok := dmiFiles[label]
+		if !ok {
+			file = label
+		}
+		// Some of the files, notably the serial numbers, are only readable
+		// by root, and not all of them exist on all systems.
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			level.Debug(logger).Log("msg", "failed to read DMI field", "file", file, "err", err)
+			continue
+		}
+		values[i] = strings.TrimSpace(string(data))
+	}
+	return values
+}
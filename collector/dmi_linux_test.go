@@ -0,0 +1,64 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nodmi
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReadDMIInfo(t *testing.T) {
+	for _, tc := range []struct {
+		redactSerials bool
+		want          map[string]string
+	}{
+		{
+			redactSerials: true,
+			want: map[string]string{
+				"bios_version":   "2.2.4",
+				"board_serial":   "",
+				"chassis_serial": "",
+				"product_name":   "PowerEdge R6515",
+				"product_serial": "",
+				"system_vendor":  "Dell Inc.",
+			},
+		},
+		{
+			redactSerials: false,
+			want: map[string]string{
+				"board_serial":      ".7N62AI2.GRTCL6944100GP.",
+				"chassis_asset_tag": "",
+				"chassis_serial":    "7N62AI2",
+				"product_serial":    "7N62AI2",
+			},
+		},
+	} {
+		values := readDMIInfo("fixtures/sys/class/dmi/id", tc.redactSerials, log.NewNopLogger())
+		if len(values) != len(dmiLabels) {
+			t.Fatalf("want %d values, got %d", len(dmiLabels), len(values))
+		}
+		got := map[string]string{}
+		for i, label := range dmiLabels {
+			got[label] = values[i]
+		}
+		for label, want := range tc.want {
+			if got[label] != want {
+				t.Errorf("redact serials %t: want %s %q, got %q", tc.redactSerials, label, want, got[label])
+			}
+		}
+	}
+}
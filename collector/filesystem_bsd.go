@@ -20,7 +20,7 @@ import (
 	"errors"
 	"unsafe"
 
-	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 /*
@@ -49,15 +49,12 @@ func (c *filesystemCollector) GetStats() (stats []filesystemStats, err error) {
 	stats = []filesystemStats{}
 	for i := 0; i < int(count); i++ {
 		mountpoint := C.GoString(&mnt[i].f_mntonname[0])
-		if c.ignoredMountPointsPattern.MatchString(mountpoint) {
-			level.Debug(c.logger).Log("msg", "Ignoring mount point", "mountpoint", mountpoint)
-			continue
+		labels := filesystemLabels{
+			device:     C.GoString(&mnt[i].f_mntfromname[0]),
+			mountPoint: rootfsStripPrefix(mountpoint),
+			fsType:     C.GoString(&mnt[i].f_fstypename[0]),
 		}
-
-		device := C.GoString(&mnt[i].f_mntfromname[0])
-		fstype := C.GoString(&mnt[i].f_fstypename[0])
-		if c.ignoredFSTypesPattern.MatchString(fstype) {
-			level.Debug(c.logger).Log("msg", "Ignoring fs type", "type", fstype)
+		if c.excluded(labels) {
 			continue
 		}
 
@@ -67,11 +64,7 @@ func (c *filesystemCollector) GetStats() (stats []filesystemStats, err error) {
 		}
 
 		stats = append(stats, filesystemStats{
-			labels: filesystemLabels{
-				device:     device,
-				mountPoint: rootfsStripPrefix(mountpoint),
-				fsType:     fstype,
-			},
+			labels:    labels,
 			size:      float64(mnt[i].f_blocks) * float64(mnt[i].f_bsize),
 			free:      float64(mnt[i].f_bfree) * float64(mnt[i].f_bsize),
 			avail:     float64(mnt[i].f_bavail) * float64(mnt[i].f_bsize),
@@ -82,3 +75,12 @@ func (c *filesystemCollector) GetStats() (stats []filesystemStats, err error) {
 	}
 	return stats, nil
 }
+
+// filesystemNamespaced returns false, as there are no mount namespaces.
+func filesystemNamespaced() bool {
+	return false
+}
+
+// updateBlockedStats is a no-op, as getmntinfo(3) is called with MNT_NOWAIT and does not block on
+// unresponsive mounts.
+func (c *filesystemCollector) updateBlockedStats(ch chan<- prometheus.Metric) {}
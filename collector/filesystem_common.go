@@ -17,7 +17,9 @@
 package collector
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -28,8 +30,9 @@ import (
 // Arch-dependent implementation must define:
 // * defIgnoredMountPoints
 // * defIgnoredFSTypes
-// * filesystemLabelNames
+// * filesystemNamespaced
 // * filesystemCollector.GetStats
+// * filesystemCollector.updateBlockedStats
 
 var (
 	ignoredMountPoints = kingpin.Flag(
@@ -40,21 +43,53 @@ var (
 		"collector.filesystem.ignored-fs-types",
 		"Regexp of filesystem types to ignore for filesystem collector.",
 	).Default(defIgnoredFSTypes).String()
+	includedMountPoints = kingpin.Flag(
+		"collector.filesystem.mount-points-include",
+		"Regexp of mount points to include for filesystem collector.",
+	).Default(".*").String()
+	includedFSTypes = kingpin.Flag(
+		"collector.filesystem.fs-types-include",
+		"Regexp of filesystem types to include for filesystem collector.",
+	).Default(".*").String()
+	includedDevices = kingpin.Flag(
+		"collector.filesystem.devices-include",
+		"Regexp of devices to include for filesystem collector.",
+	).Default(".*").String()
+	deduplicateDevices = kingpin.Flag(
+		"collector.filesystem.deduplicate-devices",
+		"Only expose the first mount point of a device mounted at multiple paths.",
+	).Bool()
+	enableMountInfo = kingpin.Flag(
+		"collector.filesystem.mount-info",
+		"Enables metrics filesystem_mount_info and filesystem_mount_option.",
+	).Bool()
 
 	filesystemLabelNames = []string{"device", "mountpoint", "fstype"}
+
+	// filesystemMountOptions are the mount options exposed by filesystem_mount_option.
+	filesystemMountOptions = []string{"noatime", "nodev", "noexec", "nosuid", "relatime"}
 )
 
 type filesystemCollector struct {
-	ignoredMountPointsPattern     *regexp.Regexp
-	ignoredFSTypesPattern         *regexp.Regexp
-	sizeDesc, freeDesc, availDesc *prometheus.Desc
-	filesDesc, filesFreeDesc      *prometheus.Desc
-	roDesc, deviceErrorDesc       *prometheus.Desc
-	logger                        log.Logger
+	ignoredMountPointsPattern      *regexp.Regexp
+	ignoredFSTypesPattern          *regexp.Regexp
+	includedMountPointsPattern     *regexp.Regexp
+	includedFSTypesPattern         *regexp.Regexp
+	includedDevicesPattern         *regexp.Regexp
+	labelNames                     []string
+	sizeDesc, freeDesc, availDesc  *prometheus.Desc
+	filesDesc, filesFreeDesc       *prometheus.Desc
+	roDesc, deviceErrorDesc        *prometheus.Desc
+	mountInfoDesc, mountOptionDesc *prometheus.Desc
+	logger                         log.Logger
 }
 
 type filesystemLabels struct {
 	device, mountPoint, fsType, options string
+	// Optional details for filesystem_mount_info.
+	major, minor, uuid, label, bindSource, mountNamespace string
+	// Path to call statfs on instead of the mount point below the rootfs.
+	path string
 }
 
 type filesystemStats struct {
@@ -75,60 +110,97 @@ func NewFilesystemCollector(logger log.Logger) (Collector, error) {
 	mountPointPattern := regexp.MustCompile(*ignoredMountPoints)
 	level.Info(logger).Log("msg", "Parsed flag --collector.filesystem.ignored-fs-types", "flag", *ignoredMountPoints)
 	filesystemsTypesPattern := regexp.MustCompile(*ignoredFSTypes)
+	includedMountPointsPattern, err := regexp.Compile(*includedMountPoints)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mount points include pattern: %w", err)
+	}
+	includedFSTypesPattern, err := regexp.Compile(*includedFSTypes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filesystem types include pattern: %w", err)
+	}
+	includedDevicesPattern, err := regexp.Compile(*includedDevices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid devices include pattern: %w", err)
+	}
+
+	// Filesystems of other mount namespaces may share mount points with the
+	// ones of the host.
+	labelNames := filesystemLabelNames
+	if filesystemNamespaced() {
+		labelNames = append(labelNames, "mount_namespace")
+	}
 
 	sizeDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "size_bytes"),
 		"Filesystem size in bytes.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	freeDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "free_bytes"),
 		"Filesystem free space in bytes.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	availDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "avail_bytes"),
 		"Filesystem space available to non-root users in bytes.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	filesDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "files"),
 		"Filesystem total file nodes.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	filesFreeDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "files_free"),
 		"Filesystem total free file nodes.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	roDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "readonly"),
 		"Filesystem read-only status.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
 	)
 
 	deviceErrorDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "device_error"),
 		"Whether an error occurred while getting statistics for the given device.",
-		filesystemLabelNames, nil,
+		labelNames, nil,
+	)
+
+	mountInfoDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "mount_info"),
+		"Filesystem mount information, including the device number, UUID and label of the device.",
+		append(filesystemLabelNames, "major", "minor", "uuid", "label", "bind_source", "mount_namespace"), nil,
+	)
+
+	mountOptionDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "mount_option"),
+		"Whether the filesystem is mounted with the given option.",
+		append(labelNames, "option"), nil,
 	)
 
 	return &filesystemCollector{
-		ignoredMountPointsPattern: mountPointPattern,
-		ignoredFSTypesPattern:     filesystemsTypesPattern,
-		sizeDesc:                  sizeDesc,
-		freeDesc:                  freeDesc,
-		availDesc:                 availDesc,
-		filesDesc:                 filesDesc,
-		filesFreeDesc:             filesFreeDesc,
-		roDesc:                    roDesc,
-		deviceErrorDesc:           deviceErrorDesc,
-		logger:                    logger,
+		ignoredMountPointsPattern:  mountPointPattern,
+		ignoredFSTypesPattern:      filesystemsTypesPattern,
+		includedMountPointsPattern: includedMountPointsPattern,
+		includedFSTypesPattern:     includedFSTypesPattern,
+		includedDevicesPattern:     includedDevicesPattern,
+		labelNames:                 labelNames,
+		sizeDesc:                   sizeDesc,
+		freeDesc:                   freeDesc,
+		availDesc:                  availDesc,
+		filesDesc:                  filesDesc,
+		filesFreeDesc:              filesFreeDesc,
+		roDesc:                     roDesc,
+		deviceErrorDesc:            deviceErrorDesc,
+		mountInfoDesc:              mountInfoDesc,
+		mountOptionDesc:            mountOptionDesc,
+		logger:                     logger,
 	}, nil
 }
 
@@ -139,44 +211,106 @@ func (c *filesystemCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	// Make sure we expose a metric once, even if there are multiple mounts
 	seen := map[filesystemLabels]bool{}
+	seenDevices := map[string]bool{}
 	for _, s := range stats {
 		if seen[s.labels] {
 			continue
 		}
 		seen[s.labels] = true
+		// Only block devices are deduplicated, pseudo filesystems like tmpfs
+		// share their device name.
+		if *deduplicateDevices && strings.HasPrefix(s.labels.device, "/") {
+			if seenDevices[s.labels.device] {
+				level.Debug(c.logger).Log("msg", "Ignoring duplicate mount of device", "device", s.labels.device, "mountpoint", s.labels.mountPoint)
+				continue
+			}
+			seenDevices[s.labels.device] = true
+		}
+		values := c.labelValues(s.labels)
 
 		ch <- prometheus.MustNewConstMetric(
 			c.deviceErrorDesc, prometheus.GaugeValue,
-			s.deviceError, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.deviceError, values...,
 		)
+		if *enableMountInfo {
+			c.updateMountInfo(ch, s.labels)
+		}
 		if s.deviceError > 0 {
 			continue
 		}
 
 		ch <- prometheus.MustNewConstMetric(
 			c.sizeDesc, prometheus.GaugeValue,
-			s.size, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.size, values...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.freeDesc, prometheus.GaugeValue,
-			s.free, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.free, values...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.availDesc, prometheus.GaugeValue,
-			s.avail, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.avail, values...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.filesDesc, prometheus.GaugeValue,
-			s.files, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.files, values...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.filesFreeDesc, prometheus.GaugeValue,
-			s.filesFree, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.filesFree, values...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.roDesc, prometheus.GaugeValue,
-			s.ro, s.labels.device, s.labels.mountPoint, s.labels.fsType,
+			s.ro, values...,
 		)
 	}
+	c.updateBlockedStats(ch)
 	return nil
 }
+
+func (c *filesystemCollector) labelValues(labels filesystemLabels) []string {
+	values := []string{labels.device, labels.mountPoint, labels.fsType}
+	if len(c.labelNames) > len(values) {
+		values = append(values, labels.mountNamespace)
+	}
+	return values
+}
+
+// excluded returns whether a filesystem is excluded by the ignore or include
+// patterns.
+func (c *filesystemCollector) excluded(labels filesystemLabels) bool {
+	switch {
+	case c.ignoredMountPointsPattern.MatchString(labels.mountPoint) || !c.includedMountPointsPattern.MatchString(labels.mountPoint):
+		level.Debug(c.logger).Log("msg", "Ignoring mount point", "mountpoint", labels.mountPoint)
+	case c.ignoredFSTypesPattern.MatchString(labels.fsType) || !c.includedFSTypesPattern.MatchString(labels.fsType):
+		level.Debug(c.logger).Log("msg", "Ignoring fs", "type", labels.fsType)
+	case !c.includedDevicesPattern.MatchString(labels.device):
+		level.Debug(c.logger).Log("msg", "Ignoring device", "device", labels.device)
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *filesystemCollector) updateMountInfo(ch chan<- prometheus.Metric, labels filesystemLabels) {
+	ch <- prometheus.MustNewConstMetric(
+		c.mountInfoDesc, prometheus.GaugeValue,
+		1, labels.device, labels.mountPoint, labels.fsType,
+		labels.major, labels.minor, labels.uuid, labels.label, labels.bindSource, labels.mountNamespace,
+	)
+
+	options := map[string]bool{}
+	for _, option := range strings.Split(labels.options, ",") {
+		options[option] = true
+	}
+	for _, option := range filesystemMountOptions {
+		var v float64
+		if options[option] {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.mountOptionDesc, prometheus.GaugeValue,
+			v, append(c.labelValues(labels), option)...,
+		)
+	}
+}
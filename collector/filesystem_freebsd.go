@@ -16,7 +16,7 @@
 package collector
 
 import (
-	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sys/unix"
 )
 
@@ -41,15 +41,12 @@ func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 	stats := []filesystemStats{}
 	for _, fs := range buf {
 		mountpoint := bytesToString(fs.Mntonname[:])
-		if c.ignoredMountPointsPattern.MatchString(mountpoint) {
-			level.Debug(c.logger).Log("msg", "Ignoring mount point", "mountpoint", mountpoint)
-			continue
+		labels := filesystemLabels{
+			device:     bytesToString(fs.Mntfromname[:]),
+			mountPoint: rootfsStripPrefix(mountpoint),
+			fsType:     bytesToString(fs.Fstypename[:]),
 		}
-
-		device := bytesToString(fs.Mntfromname[:])
-		fstype := bytesToString(fs.Fstypename[:])
-		if c.ignoredFSTypesPattern.MatchString(fstype) {
-			level.Debug(c.logger).Log("msg", "Ignoring fs type", "type", fstype)
+		if c.excluded(labels) {
 			continue
 		}
 
@@ -59,11 +56,7 @@ func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 		}
 
 		stats = append(stats, filesystemStats{
-			labels: filesystemLabels{
-				device:     device,
-				mountPoint: rootfsStripPrefix(mountpoint),
-				fsType:     fstype,
-			},
+			labels:    labels,
 			size:      float64(fs.Blocks) * float64(fs.Bsize),
 			free:      float64(fs.Bfree) * float64(fs.Bsize),
 			avail:     float64(fs.Bavail) * float64(fs.Bsize),
@@ -74,3 +67,12 @@ func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
 	}
 	return stats, nil
 }
+
+// filesystemNamespaced returns false, as there are no mount namespaces.
+func filesystemNamespaced() bool {
+	return false
+}
+
+// updateBlockedStats is a no-op, as getfsstat(2) is called with MNT_NOWAIT and does not block on
+// unresponsive mounts.
+func (c *filesystemCollector) updateBlockedStats(ch chan<- prometheus.Metric) {}
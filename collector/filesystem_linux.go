@@ -20,13 +20,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
 	"golang.org/x/sys/unix"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -36,106 +43,223 @@ const (
 	defIgnoredFSTypes     = "^(autofs|binfmt_misc|bpf|cgroup2?|configfs|debugfs|devpts|devtmpfs|fusectl|hugetlbfs|iso9660|mqueue|nsfs|overlay|proc|procfs|pstore|rpc_pipefs|securityfs|selinuxfs|squashfs|sysfs|tracefs)$"
 )
 
-var mountTimeout = kingpin.Flag("collector.filesystem.mount-timeout",
-	"how long to wait for a mount to respond before marking it as stale").
-	Hidden().Default("5s").Duration()
-var stuckMounts = make(map[string]struct{})
-var stuckMountsMtx = &sync.Mutex{}
+var (
+	mountTimeout = kingpin.Flag("collector.filesystem.mount-timeout",
+		"How long to wait for statfs() on a mount to respond before reporting a device error.").
+		Default("5s").Duration()
+	statfsWorkers = kingpin.Flag("collector.filesystem.statfs-workers",
+		"Maximum number of concurrent statfs() calls, not counting calls blocked on unresponsive mounts for longer than the mount timeout.").
+		Default("4").Int()
+	mountNamespaceProcesses = kingpin.Flag("collector.filesystem.mount-namespace-processes",
+		"Regexp of process names to also discover the filesystems of their mount namespaces for, e.g. of container runtimes.").
+		String()
+
+	// The statfs pool, whose blocked calls outlive the scrape that made them.
+	statfsPoolOnce sync.Once
+	statfsCalls    *statfsPool
+
+	errStatfsTimeout = errors.New("statfs timed out")
+	errStatfsBlocked = errors.New("previous statfs is still blocked")
+
+	blockedStatfsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "filesystem", "blocked_statfs_calls"),
+		"Number of statfs() calls blocked on unresponsive mounts for longer than the mount timeout.",
+		nil, nil,
+	)
+)
 
 // GetStats returns filesystem stats.
 func (c *filesystemCollector) GetStats() ([]filesystemStats, error) {
-	mps, err := mountPointDetails(c.logger)
+	mps, err := c.mountDetails()
 	if err != nil {
 		return nil, err
 	}
-	stats := []filesystemStats{}
+	statfsPoolOnce.Do(func() {
+		statfsCalls = newStatfsPool(*statfsWorkers)
+	})
+
+	var filtered []filesystemLabels
 	for _, labels := range mps {
-		if c.ignoredMountPointsPattern.MatchString(labels.mountPoint) {
-			level.Debug(c.logger).Log("msg", "Ignoring mount point", "mountpoint", labels.mountPoint)
+		if c.excluded(labels) {
 			continue
 		}
-		if c.ignoredFSTypesPattern.MatchString(labels.fsType) {
-			level.Debug(c.logger).Log("msg", "Ignoring fs", "type", labels.fsType)
-			continue
+		filtered = append(filtered, labels)
+	}
+
+	stats := make([]filesystemStats, len(filtered))
+	wg := sync.WaitGroup{}
+	wg.Add(len(filtered))
+	for i, labels := range filtered {
+		go func(i int, labels filesystemLabels) {
+			stats[i] = c.processStat(labels)
+			wg.Done()
+		}(i, labels)
+	}
+	wg.Wait()
+	return stats, nil
+}
+
+func (c *filesystemCollector) processStat(labels filesystemLabels) filesystemStats {
+	path := labels.path
+	if path == "" {
+		path = rootfsFilePath(labels.mountPoint)
+	}
+	buf, err := statfsCalls.statfs(path, *mountTimeout, c.logger)
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "Error on statfs() system call", "rootfs", path, "err", err)
+		return filesystemStats{
+			labels:      labels,
+			deviceError: 1,
 		}
-		stuckMountsMtx.Lock()
-		if _, ok := stuckMounts[labels.mountPoint]; ok {
-			stats = append(stats, filesystemStats{
-				labels:      labels,
-				deviceError: 1,
-			})
-			level.Debug(c.logger).Log("msg", "Mount point is in an unresponsive state", "mountpoint", labels.mountPoint)
-			stuckMountsMtx.Unlock()
-			continue
+	}
+
+	var ro float64
+	for _, option := range strings.Split(labels.options, ",") {
+		if option == "ro" {
+			ro = 1
+			break
 		}
-		stuckMountsMtx.Unlock()
+	}
 
-		// The success channel is used do tell the "watcher" that the stat
-		// finished successfully. The channel is closed on success.
-		success := make(chan struct{})
-		go stuckMountWatcher(labels.mountPoint, success, c.logger)
+	return filesystemStats{
+		labels:    labels,
+		size:      float64(buf.Blocks) * float64(buf.Bsize),
+		free:      float64(buf.Bfree) * float64(buf.Bsize),
+		avail:     float64(buf.Bavail) * float64(buf.Bsize),
+		files:     float64(buf.Files),
+		filesFree: float64(buf.Ffree),
+		ro:        ro,
+	}
+}
 
-		buf := new(unix.Statfs_t)
-		err = unix.Statfs(rootfsFilePath(labels.mountPoint), buf)
-		stuckMountsMtx.Lock()
-		close(success)
-		// If the mount has been marked as stuck, unmark it and log it's recovery.
-		if _, ok := stuckMounts[labels.mountPoint]; ok {
-			level.Debug(c.logger).Log("msg", "Mount point has recovered, monitoring will resume", "mountpoint", labels.mountPoint)
-			delete(stuckMounts, labels.mountPoint)
-		}
-		stuckMountsMtx.Unlock()
+// updateBlockedStats exposes the number of statfs calls blocked on
+// unresponsive mounts.
+func (c *filesystemCollector) updateBlockedStats(ch chan<- prometheus.Metric) {
+	if statfsCalls == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(blockedStatfsDesc, prometheus.GaugeValue, float64(statfsCalls.blockedCalls()))
+}
 
-		if err != nil {
-			stats = append(stats, filesystemStats{
-				labels:      labels,
-				deviceError: 1,
-			})
+// statfsPool runs statfs calls on a bounded number of goroutines. Concurrent
+// calls for the same path share a single statfs. A call that does not return
+// before its deadline gives up its worker and is marked as blocked until it
+// does, and further calls for the same path fail immediately in the meantime,
+// so an unresponsive mount neither stalls scrapes nor leaks goroutines.
+type statfsPool struct {
+	workers chan struct{}
 
-			level.Debug(c.logger).Log("msg", "Error on statfs() system call", "rootfs", rootfsFilePath(labels.mountPoint), "err", err)
-			continue
-		}
+	mtx   sync.Mutex
+	calls map[string]*statfsCall
+}
 
-		var ro float64
-		for _, option := range strings.Split(labels.options, ",") {
-			if option == "ro" {
-				ro = 1
-				break
-			}
-		}
+// statfsCall is a statfs call in flight. The result is set before done is
+// closed.
+type statfsCall struct {
+	done    chan struct{}
+	blocked bool
+	buf     *unix.Statfs_t
+	err     error
+}
 
-		stats = append(stats, filesystemStats{
-			labels:    labels,
-			size:      float64(buf.Blocks) * float64(buf.Bsize),
-			free:      float64(buf.Bfree) * float64(buf.Bsize),
-			avail:     float64(buf.Bavail) * float64(buf.Bsize),
-			files:     float64(buf.Files),
-			filesFree: float64(buf.Ffree),
-			ro:        ro,
-		})
+func newStatfsPool(workers int) *statfsPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &statfsPool{
+		workers: make(chan struct{}, workers),
+		calls:   map[string]*statfsCall{},
 	}
-	return stats, nil
 }
 
-// stuckMountWatcher listens on the given success channel and if the channel closes
-// then the watcher does nothing. If instead the timeout is reached, the
-// mount point that is being watched is marked as stuck.
-func stuckMountWatcher(mountPoint string, success chan struct{}, logger log.Logger) {
+func (p *statfsPool) statfs(path string, timeout time.Duration, logger log.Logger) (*unix.Statfs_t, error) {
+	return p.run(path, timeout, logger, func(buf *unix.Statfs_t) error {
+		return unix.Statfs(path, buf)
+	})
+}
+
+func (p *statfsPool) run(path string, timeout time.Duration, logger log.Logger, statfs func(*unix.Statfs_t) error) (*unix.Statfs_t, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	p.mtx.Lock()
+	if call, ok := p.calls[path]; ok {
+		blocked := call.blocked
+		p.mtx.Unlock()
+		if blocked {
+			return nil, errStatfsBlocked
+		}
+		// Wait for the result of the call in flight.
+		select {
+		case <-call.done:
+			return call.buf, call.err
+		case <-deadline.C:
+			return nil, errStatfsTimeout
+		}
+	}
+	call := &statfsCall{done: make(chan struct{})}
+	p.calls[path] = call
+	p.mtx.Unlock()
+
+	select {
+	case p.workers <- struct{}{}:
+	case <-deadline.C:
+		// All workers are busy with other mounts.
+		p.mtx.Lock()
+		call.err = errStatfsTimeout
+		close(call.done)
+		delete(p.calls, path)
+		p.mtx.Unlock()
+		return nil, errStatfsTimeout
+	}
+
+	go func() {
+		buf := new(unix.Statfs_t)
+		err := statfs(buf)
+
+		p.mtx.Lock()
+		if call.blocked {
+			level.Debug(logger).Log("msg", "Mount point has recovered, monitoring will resume", "mountpoint", path)
+		} else {
+			<-p.workers
+		}
+		call.buf, call.err = buf, err
+		close(call.done)
+		delete(p.calls, path)
+		p.mtx.Unlock()
+	}()
+
 	select {
-	case <-success:
-		// Success
-	case <-time.After(*mountTimeout):
-		// Timed out, mark mount as stuck
-		stuckMountsMtx.Lock()
+	case <-call.done:
+		return call.buf, call.err
+	case <-deadline.C:
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
 		select {
-		case <-success:
-			// Success came in just after the timeout was reached, don't label the mount as stuck
+		case <-call.done:
+			return call.buf, call.err
 		default:
-			level.Debug(logger).Log("msg", "Mount point timed out, it is being labeled as stuck and will not be monitored", "mountpoint", mountPoint)
-			stuckMounts[mountPoint] = struct{}{}
 		}
-		stuckMountsMtx.Unlock()
+		level.Debug(logger).Log("msg", "Mount point timed out, it is being labeled as stuck and will not be monitored", "mountpoint", path)
+		// The blocked call no longer counts against the workers.
+		call.blocked = true
+		<-p.workers
+		return nil, errStatfsTimeout
+	}
+}
+
+// blockedCalls returns the number of calls that exceeded their deadline and
+// have not returned yet.
+func (p *statfsPool) blockedCalls() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	n := 0
+	for _, call := range p.calls {
+		if call.blocked {
+			n++
+		}
 	}
+	return n
 }
 
 func mountPointDetails(logger log.Logger) ([]filesystemLabels, error) {
@@ -166,8 +290,7 @@ func parseFilesystemLabels(r io.Reader) ([]filesystemLabels, error) {
 
 		// Ensure we handle the translation of \040 and \011
 		// as per fstab(5).
-		parts[1] = strings.Replace(parts[1], "\\040", " ", -1)
-		parts[1] = strings.Replace(parts[1], "\\011", "\t", -1)
+		parts[1] = unescapeMountPoint(parts[1])
 
 		filesystems = append(filesystems, filesystemLabels{
 			device:     parts[0],
@@ -179,3 +302,235 @@ func parseFilesystemLabels(r io.Reader) ([]filesystemLabels, error) {
 
 	return filesystems, scanner.Err()
 }
+
+// filesystemNamespaced returns whether filesystems of other mount namespaces
+// are discovered.
+func filesystemNamespaced() bool {
+	return *mountNamespaceProcesses != ""
+}
+
+// mountDetails returns the mounts of PID 1 and, if enabled, the mounts of the
+// mount namespaces of the processes matching
+// --collector.filesystem.mount-namespace-processes.
+func (c *filesystemCollector) mountDetails() ([]filesystemLabels, error) {
+	mps, err := mountPointDetails(c.logger)
+	if err != nil {
+		return nil, err
+	}
+	if !*enableMountInfo && !filesystemNamespaced() {
+		return mps, nil
+	}
+
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+	var links diskLinks
+	if *enableMountInfo {
+		links = readDiskLinks(c.logger)
+	}
+
+	var hostNamespace string
+	if proc, err := fs.Proc(1); err == nil {
+		hostNamespace = mountNamespace(proc, c.logger)
+		if *enableMountInfo {
+			addMountInfo(proc, mps, links, c.logger)
+		}
+	} else {
+		level.Debug(c.logger).Log("msg", "failed to open process", "pid", 1, "err", err)
+	}
+	for i := range mps {
+		mps[i].mountNamespace = hostNamespace
+	}
+
+	if !filesystemNamespaced() {
+		return mps, nil
+	}
+	pattern, err := regexp.Compile(*mountNamespaceProcesses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mount namespace processes pattern: %w", err)
+	}
+	nsMounts, err := namespaceMountDetails(fs, pattern, hostNamespace, links, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	return append(mps, nsMounts...), nil
+}
+
+// namespaceMountDetails returns the mounts of the mount namespaces of the
+// processes matching pattern, other than the one of PID 1. Each namespace is
+// read through the first matching process found in it.
+func namespaceMountDetails(fs procfs.FS, pattern *regexp.Regexp, hostNamespace string, links diskLinks, logger log.Logger) ([]filesystemLabels, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	var mps []filesystemLabels
+	seen := map[string]bool{"": true, hostNamespace: true}
+	for _, proc := range procs {
+		// Processes may exit at any time, so errors are not fatal.
+		comm, err := proc.Comm()
+		if err != nil || !pattern.MatchString(comm) {
+			continue
+		}
+		ns := mountNamespace(proc, logger)
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+
+		mountInfo, err := proc.MountInfo()
+		if err != nil {
+			level.Debug(logger).Log("msg", "failed to read mountinfo", "pid", proc.PID, "err", err)
+			continue
+		}
+		for _, m := range mountInfo {
+			mountPoint := unescapeMountPoint(m.MountPoint)
+			labels := filesystemLabels{
+				device:         m.Source,
+				mountPoint:     mountPoint,
+				fsType:         m.FSType,
+				options:        mountInfoOptions(m),
+				mountNamespace: ns,
+				path:           procFilePath(filepath.Join(strconv.Itoa(proc.PID), "root", mountPoint)),
+			}
+			if *enableMountInfo {
+				setMountInfo(&labels, m, links)
+			}
+			mps = append(mps, labels)
+		}
+	}
+	return mps, nil
+}
+
+// mountNamespace returns the inode number of the mount namespace of a
+// process, or an empty string if it is unknown.
+func mountNamespace(proc procfs.Proc, logger log.Logger) string {
+	namespaces, err := proc.Namespaces()
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to read namespaces", "pid", proc.PID, "err", err)
+		return ""
+	}
+	ns, ok := namespaces["mnt"]
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(ns.Inode), 10)
+}
+
+// mountInfoOptions returns the per mount and superblock options of a mount,
+// like the options of /proc/mounts.
+func mountInfoOptions(m *procfs.MountInfo) string {
+	seen := map[string]bool{}
+	var options []string
+	for _, opts := range []map[string]string{m.Options, m.SuperOptions} {
+		for k, v := range opts {
+			option := k
+			if v != "" {
+				option += "=" + v
+			}
+			if !seen[option] {
+				seen[option] = true
+				options = append(options, option)
+			}
+		}
+	}
+	sort.Strings(options)
+	return strings.Join(options, ",")
+}
+
+// addMountInfo adds the details of the mountinfo of a process to its mounts.
+func addMountInfo(proc procfs.Proc, mps []filesystemLabels, links diskLinks, logger log.Logger) {
+	mountInfo, err := proc.MountInfo()
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to read mountinfo", "pid", proc.PID, "err", err)
+		return
+	}
+
+	// Later mounts hide earlier ones on the same mount point, as in /proc/1/mounts.
+	mounts := make(map[string]*procfs.MountInfo, len(mountInfo))
+	for _, m := range mountInfo {
+		mounts[rootfsStripPrefix(unescapeMountPoint(m.MountPoint))] = m
+	}
+	for i := range mps {
+		if m, ok := mounts[mps[i].mountPoint]; ok {
+			setMountInfo(&mps[i], m, links)
+		}
+	}
+}
+
+// setMountInfo sets the device number, bind mount source, filesystem UUID and
+// label of a mount.
+func setMountInfo(labels *filesystemLabels, m *procfs.MountInfo, links diskLinks) {
+	if parts := strings.SplitN(m.MajorMinorVer, ":", 2); len(parts) == 2 {
+		labels.major, labels.minor = parts[0], parts[1]
+	}
+	if m.Root != "/" {
+		labels.bindSource = unescapeMountPoint(m.Root)
+	}
+	name := blockDeviceName(m.MajorMinorVer)
+	if name == "" {
+		name = filepath.Base(labels.device)
+	}
+	labels.uuid = links.uuids[name]
+	labels.label = links.labels[name]
+}
+
+// blockDeviceName returns the kernel name of the block device with the given
+// major:minor number, or an empty string if there is no such block device.
+func blockDeviceName(majorMinor string) string {
+	target, err := os.Readlink(sysFilePath(filepath.Join("dev/block", majorMinor)))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// diskLinks maps device names to their filesystem UUIDs and labels.
+type diskLinks struct {
+	uuids, labels map[string]string
+}
+
+func readDiskLinks(logger log.Logger) diskLinks {
+	return diskLinks{
+		uuids:  readDiskLinkDir("by-uuid", logger),
+		labels: readDiskLinkDir("by-label", logger),
+	}
+}
+
+// readDiskLinkDir maps device names to the names of their links in the given
+// /dev/disk directory.
+func readDiskLinkDir(dir string, logger log.Logger) map[string]string {
+	links := map[string]string{}
+	path := rootfsFilePath(filepath.Join("dev/disk", dir))
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to read device links", "path", path, "err", err)
+		return links
+	}
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(path, entry.Name()))
+		if err != nil {
+			continue
+		}
+		links[filepath.Base(target)] = unescapeDiskLink(entry.Name())
+	}
+	return links
+}
+
+// unescapeDiskLink translates the \xNN escapes udev uses in link names.
+func unescapeDiskLink(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -14,10 +14,14 @@
 package collector
 
 import (
-	"github.com/go-kit/kit/log"
+	"fmt"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/log"
+	"golang.org/x/sys/unix"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -139,3 +143,172 @@ func TestPathRootfs(t *testing.T) {
 		}
 	}
 }
+
+func TestStatfsPool(t *testing.T) {
+	const workers = 4
+	pool := newStatfsPool(workers)
+	logger := log.NewNopLogger()
+
+	unblock := make(chan struct{})
+	stuck := func(buf *unix.Statfs_t) error {
+		<-unblock
+		return nil
+	}
+	ok := func(buf *unix.Statfs_t) error {
+		buf.Blocks = 42
+		return nil
+	}
+
+	// More mounts are stuck than there are workers.
+	for i := 0; i <= workers; i++ {
+		path := fmt.Sprintf("/stuck%d", i)
+		if _, err := pool.run(path, 10*time.Millisecond, logger, stuck); err != errStatfsTimeout {
+			t.Fatalf("%s: want %v, got %v", path, errStatfsTimeout, err)
+		}
+	}
+	if n := pool.blockedCalls(); n != workers+1 {
+		t.Fatalf("want %d blocked calls, got %d", workers+1, n)
+	}
+	// A stuck mount fails immediately while its call is blocked.
+	if _, err := pool.run("/stuck0", time.Hour, logger, stuck); err != errStatfsBlocked {
+		t.Fatalf("want %v, got %v", errStatfsBlocked, err)
+	}
+	// Blocked calls don't hold the workers of healthy mounts.
+	buf, err := pool.run("/ok", time.Second, logger, ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Blocks != 42 {
+		t.Errorf("want 42 blocks, got %d", buf.Blocks)
+	}
+
+	close(unblock)
+	deadline := time.Now().Add(time.Second)
+	for pool.blockedCalls() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("blocked calls did not recover")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := pool.run("/stuck0", time.Second, logger, ok); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatfsPoolSharedCall(t *testing.T) {
+	pool := newStatfsPool(1)
+	logger := log.NewNopLogger()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := func(buf *unix.Statfs_t) error {
+		close(started)
+		<-release
+		buf.Blocks = 42
+		return nil
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := pool.run("/slow", time.Hour, logger, slow)
+		errs <- err
+	}()
+	<-started
+
+	// A concurrent call for a path with a healthy call in flight waits for
+	// its result instead of failing.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	buf, err := pool.run("/slow", time.Hour, logger, func(buf *unix.Statfs_t) error {
+		t.Error("want shared call, got second statfs")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Blocks != 42 {
+		t.Errorf("want 42 blocks, got %d", buf.Blocks)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n := pool.blockedCalls(); n != 0 {
+		t.Errorf("want no blocked calls, got %d", n)
+	}
+}
+
+func TestMountDetails(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.procfs", "./fixtures/proc",
+		"--path.sysfs", "./fixtures/sys",
+		"--path.rootfs", "./fixtures",
+		"--collector.filesystem.mount-info",
+		"--collector.filesystem.mount-namespace-processes", "^containerd-shim$",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := filesystemCollector{logger: log.NewNopLogger()}
+	mps, err := c.mountDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]filesystemLabels{}
+	for _, labels := range mps {
+		got[labels.mountNamespace+":"+labels.mountPoint] = labels
+	}
+
+	expected := []filesystemLabels{
+		{device: "/dev/dm-2", mountPoint: "/", fsType: "ext4", major: "254", minor: "2", uuid: "2c37d2a8-8d6b-4f3a-9b1e-6f0a5e4b7c1d", label: "root fs", mountNamespace: "4026531840"},
+		{device: "/dev/sda3", mountPoint: "/boot", fsType: "ext2", major: "8", minor: "3", uuid: "6d0bbd24-5c89-4d5b-a1f8-25c7a6e5b0f2", mountNamespace: "4026531840"},
+		{device: "tmpfs", mountPoint: "/run/lock", fsType: "tmpfs", major: "0", minor: "22", bindSource: "/lock", mountNamespace: "4026531840"},
+		{device: "tmpfs", mountPoint: "/dev/shm", fsType: "tmpfs", mountNamespace: "4026531840"},
+		{device: "overlay", mountPoint: "/", fsType: "overlay", major: "0", minor: "60", mountNamespace: "4026532250", path: "fixtures/proc/11/root"},
+		{device: "/dev/sda3", mountPoint: "/data", fsType: "ext2", major: "8", minor: "3", uuid: "6d0bbd24-5c89-4d5b-a1f8-25c7a6e5b0f2", bindSource: "/volumes/data", mountNamespace: "4026532250", path: "fixtures/proc/11/root/data"},
+	}
+	for _, want := range expected {
+		labels, ok := got[want.mountNamespace+":"+want.mountPoint]
+		if !ok {
+			t.Errorf("%s:%s: missing mount", want.mountNamespace, want.mountPoint)
+			continue
+		}
+		// Options are checked separately.
+		labels.options = ""
+		if labels != want {
+			t.Errorf("%s:%s: want %+v, got %+v", want.mountNamespace, want.mountPoint, want, labels)
+		}
+	}
+	if opts := got["4026532250:/data"].options; opts != "noatime,rw" {
+		t.Errorf("want options %q, got %q", "noatime,rw", opts)
+	}
+}
+
+func TestFilesystemExcluded(t *testing.T) {
+	c := filesystemCollector{
+		ignoredMountPointsPattern:  regexp.MustCompile("^/dev($|/)"),
+		ignoredFSTypesPattern:      regexp.MustCompile("^tmpfs$"),
+		includedMountPointsPattern: regexp.MustCompile("^/(data|boot)?$"),
+		includedFSTypesPattern:     regexp.MustCompile("^ext[234]$|^xfs$|^tmpfs$"),
+		includedDevicesPattern:     regexp.MustCompile("^/dev/(sd|dm-)"),
+		logger:                     log.NewNopLogger(),
+	}
+
+	tests := []struct {
+		labels   filesystemLabels
+		excluded bool
+	}{
+		{filesystemLabels{device: "/dev/dm-2", mountPoint: "/", fsType: "ext4"}, false},
+		{filesystemLabels{device: "/dev/sda3", mountPoint: "/boot", fsType: "ext2"}, false},
+		{filesystemLabels{device: "/dev/sda3", mountPoint: "/srv", fsType: "ext2"}, true},
+		{filesystemLabels{device: "/dev/sdb1", mountPoint: "/data", fsType: "btrfs"}, true},
+		{filesystemLabels{device: "/dev/nvme0n1p1", mountPoint: "/data", fsType: "xfs"}, true},
+		{filesystemLabels{device: "tmpfs", mountPoint: "/", fsType: "tmpfs"}, true},
+	}
+	for _, test := range tests {
+		if got := c.excluded(test.labels); got != test.excluded {
+			t.Errorf("%+v: want excluded %t, got %t", test.labels, test.excluded, got)
+		}
+	}
+}
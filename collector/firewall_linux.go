@@ -0,0 +1,341 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nofirewall
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	firewallIptablesTimeout = kingpin.Flag("collector.firewall.iptables-timeout", "Timeout for running iptables-save, which waits for the xtables lock.").Default("5s").Duration()
+	firewallFixtures        = kingpin.Flag("collector.firewall.fixtures", "test fixtures to use for firewall collector metrics").Default("").Hidden().String()
+)
+
+// firewallIptablesSave are the commands used if nftables is unavailable, by
+// the family of the tables they save.
+var firewallIptablesSave = []struct {
+	family  string
+	command string
+}{
+	{"ip", "iptables-save"},
+	{"ip6", "ip6tables-save"},
+}
+
+type firewallCollector struct {
+	rulePackets    *prometheus.Desc
+	ruleBytes      *prometheus.Desc
+	counterPackets *prometheus.Desc
+	counterBytes   *prometheus.Desc
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector("firewall", defaultDisabled, NewFirewallCollector)
+}
+
+// NewFirewallCollector returns a new Collector exposing the counters of
+// nftables or iptables rules.
+func NewFirewallCollector(logger log.Logger) (Collector, error) {
+	const subsystem = "firewall"
+
+	ruleLabels := []string{"family", "table", "chain", "rule"}
+	counterLabels := []string{"family", "table", "name"}
+	return &firewallCollector{
+		rulePackets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "rule_packets_total"),
+			"Packets matched by firewall rules, by rule comment or else nftables handle or iptables position in the chain.",
+			ruleLabels, nil,
+		),
+		ruleBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "rule_bytes_total"),
+			"Bytes matched by firewall rules, by rule comment or else nftables handle or iptables position in the chain.",
+			ruleLabels, nil,
+		),
+		counterPackets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "counter_packets_total"),
+			"Packets counted by named nftables counters.",
+			counterLabels, nil,
+		),
+		counterBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "counter_bytes_total"),
+			"Bytes counted by named nftables counters.",
+			counterLabels, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+// firewallRule is the counter of a rule. Rule is the comment of the rule, or
+// else its handle for nftables or its position in the chain for iptables.
+type firewallRule struct {
+	Family  string
+	Table   string
+	Chain   string
+	Rule    string
+	Packets uint64
+	Bytes   uint64
+}
+
+// firewallCounter is a named nftables counter.
+type firewallCounter struct {
+	Family  string
+	Table   string
+	Name    string
+	Packets uint64
+	Bytes   uint64
+}
+
+type firewallRuleKey struct {
+	family string
+	table  string
+	chain  string
+	rule   string
+}
+
+type firewallRuleCounts struct {
+	packets uint64
+	bytes   uint64
+}
+
+func (c *firewallCollector) Update(ch chan<- prometheus.Metric) error {
+	rules, counters, err := c.firewallCounters()
+	if err != nil {
+		return fmt.Errorf("could not get firewall counters: %w", err)
+	}
+
+	// Several rules of a chain may have the same comment.
+	ruleCounts := map[firewallRuleKey]firewallRuleCounts{}
+	for _, r := range rules {
+		k := firewallRuleKey{r.Family, r.Table, r.Chain, r.Rule}
+		counts := ruleCounts[k]
+		counts.packets += r.Packets
+		counts.bytes += r.Bytes
+		ruleCounts[k] = counts
+	}
+	for k, v := range ruleCounts {
+		ch <- prometheus.MustNewConstMetric(c.rulePackets, prometheus.CounterValue, float64(v.packets), k.family, k.table, k.chain, k.rule)
+		ch <- prometheus.MustNewConstMetric(c.ruleBytes, prometheus.CounterValue, float64(v.bytes), k.family, k.table, k.chain, k.rule)
+	}
+	for _, counter := range counters {
+		ch <- prometheus.MustNewConstMetric(c.counterPackets, prometheus.CounterValue, float64(counter.Packets), counter.Family, counter.Table, counter.Name)
+		ch <- prometheus.MustNewConstMetric(c.counterBytes, prometheus.CounterValue, float64(counter.Bytes), counter.Family, counter.Table, counter.Name)
+	}
+	return nil
+}
+
+// firewallCounters returns the rule and named counters of nftables. It falls
+// back to the rule counters of iptables-save and ip6tables-save if nftables
+// can't be dumped via netlink or has no counters, as with iptables-legacy.
+func (c *firewallCollector) firewallCounters() ([]firewallRule, []firewallCounter, error) {
+	rules, counters, nftErr := c.nftablesCounters()
+	if nftErr == nil && (len(rules) > 0 || len(counters) > 0) {
+		return rules, counters, nil
+	}
+	if nftErr != nil {
+		level.Debug(c.logger).Log("msg", "failed to dump nftables via netlink, falling back to iptables-save", "err", nftErr)
+	}
+
+	rules = nil
+	found := false
+	for _, s := range firewallIptablesSave {
+		r, err := c.iptablesSave(s.family, s.command)
+		if err != nil {
+			if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+				level.Debug(c.logger).Log("msg", "iptables-save command not available", "command", s.command, "err", err)
+				continue
+			}
+			return nil, nil, err
+		}
+		found = true
+		rules = append(rules, r...)
+	}
+	if !found && nftErr != nil {
+		return nil, nil, nftErr
+	}
+	return rules, nil, nil
+}
+
+func (c *firewallCollector) nftablesCounters() ([]firewallRule, []firewallCounter, error) {
+	var (
+		ruleMsgs, objMsgs []netlink.Message
+		err               error
+	)
+	if *firewallFixtures != "" {
+		ruleMsgs, err = readNetlinkFixture(filepath.Join(*firewallFixtures, "nftables-rules.hex"))
+		if err != nil {
+			return nil, nil, err
+		}
+		objMsgs, err = readNetlinkFixture(filepath.Join(*firewallFixtures, "nftables-objects.hex"))
+	} else {
+		ruleMsgs, objMsgs, err = dumpNftables()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rules, err := parseNftRules(ruleMsgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	counters, err := parseNftCounters(objMsgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rules, counters, nil
+}
+
+// iptablesSave returns the rule counters saved by an iptables-save command,
+// or read from its saved output in the fixtures.
+func (c *firewallCollector) iptablesSave(family, command string) ([]firewallRule, error) {
+	if *firewallFixtures != "" {
+		file, err := os.Open(filepath.Join(*firewallFixtures, command+".txt"))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return parseIptablesSave(file, family)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *firewallIptablesTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, command, "-c").Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %s", command, *firewallIptablesTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", command, err)
+	}
+	return parseIptablesSave(bytes.NewReader(out), family)
+}
+
+// parseIptablesSave parses the output of iptables-save -c, in which each rule
+// is preceded by its packet and byte counters, e.g.
+// [12:720] -A INPUT -p tcp -m tcp --dport 22 -m comment --comment "ssh" -j ACCEPT
+func parseIptablesSave(r io.Reader, family string) ([]firewallRule, error) {
+	var (
+		rules     []firewallRule
+		table     string
+		positions = map[string]int{}
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+			positions = map[string]int{}
+			continue
+		case !strings.HasPrefix(line, "["):
+			// Comments, chains with their policy counters and COMMIT.
+			continue
+		}
+
+		end := strings.Index(line, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid iptables rule %q", line)
+		}
+		counts := strings.Split(line[1:end], ":")
+		if len(counts) != 2 {
+			return nil, fmt.Errorf("invalid iptables rule counters %q", line[:end+1])
+		}
+		packets, err := strconv.ParseUint(counts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iptables rule packets %q: %w", counts[0], err)
+		}
+		octets, err := strconv.ParseUint(counts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iptables rule bytes %q: %w", counts[1], err)
+		}
+
+		args := splitIptablesArgs(line[end+1:])
+		if len(args) < 2 || args[0] != "-A" {
+			return nil, fmt.Errorf("invalid iptables rule %q", line)
+		}
+		chain := args[1]
+		positions[chain]++
+
+		rule := firewallRule{
+			Family:  family,
+			Table:   table,
+			Chain:   chain,
+			Rule:    strconv.Itoa(positions[chain]),
+			Packets: packets,
+			Bytes:   octets,
+		}
+		for i, arg := range args[:len(args)-1] {
+			if arg == "--comment" {
+				rule.Rule = args[i+1]
+				break
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// splitIptablesArgs splits the arguments of a rule saved by iptables-save,
+// which quotes arguments containing spaces in double quotes and escapes double
+// quotes and backslashes in them with a backslash.
+func splitIptablesArgs(s string) []string {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quoted  bool
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,286 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nofirewall
+
+package collector
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseIptablesSave(t *testing.T) {
+	out := `# Generated by iptables-save v1.8.4 on Mon Jan  6 10:00:00 2020
+*filter
+:INPUT DROP [52:3120]
+:FORWARD ACCEPT [0:0]
+:OUTPUT ACCEPT [1000:84000]
+[12:720] -A INPUT -p tcp -m tcp --dport 22 -m comment --comment "allow ssh" -j ACCEPT
+[3:180] -A INPUT -i lo -j ACCEPT
+[0:0] -A INPUT -m comment --comment "say \"hi\"" -j ACCEPT
+[7:420] -A OUTPUT -m comment --comment web -j ACCEPT
+COMMIT
+*nat
+:PREROUTING ACCEPT [5:300]
+[1:60] -A PREROUTING -i lo -j ACCEPT
+COMMIT
+`
+	rules, err := parseIptablesSave(strings.NewReader(out), "ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []firewallRule{
+		{Family: "ip", Table: "filter", Chain: "INPUT", Rule: "allow ssh", Packets: 12, Bytes: 720},
+		{Family: "ip", Table: "filter", Chain: "INPUT", Rule: "2", Packets: 3, Bytes: 180},
+		{Family: "ip", Table: "filter", Chain: "INPUT", Rule: `say "hi"`},
+		{Family: "ip", Table: "filter", Chain: "OUTPUT", Rule: "web", Packets: 7, Bytes: 420},
+		{Family: "ip", Table: "nat", Chain: "PREROUTING", Rule: "1", Packets: 1, Bytes: 60},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("want %+v, got %+v", want, rules)
+	}
+
+	for _, line := range []string{
+		"[12] -A INPUT -j ACCEPT",
+		"[a:1] -A INPUT -j ACCEPT",
+		"[1:2 -A INPUT -j ACCEPT",
+		"[1:2] -I INPUT -j ACCEPT",
+	} {
+		if _, err := parseIptablesSave(strings.NewReader(line), "ip"); err == nil {
+			t.Errorf("want error for %q, got none", line)
+		}
+	}
+}
+
+func TestParseNftRules(t *testing.T) {
+	rules, err := parseNftRules(testNftRuleMessages(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []firewallRule{
+		{Family: "inet", Table: "filter", Chain: "input", Rule: "allow ssh", Packets: 1024, Bytes: 65536},
+		{Family: "inet", Table: "filter", Chain: "input", Rule: "5", Packets: 10, Bytes: 840},
+		{Family: "ip", Table: "filter", Chain: "INPUT", Rule: "iptables-nft rule", Packets: 3, Bytes: 180},
+		{Family: "ip6", Table: "filter", Chain: "forward", Rule: "2", Packets: 0, Bytes: 0},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("want %+v, got %+v", want, rules)
+	}
+
+	if _, err := parseNftRules([]netlink.Message{{Data: []byte{unix.NFPROTO_INET}}}); err == nil {
+		t.Error("want error for short message, got none")
+	}
+}
+
+func TestParseNftCounters(t *testing.T) {
+	counters, err := parseNftCounters(testNftObjectMessages(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []firewallCounter{
+		{Family: "inet", Table: "filter", Name: "http", Packets: 5000, Bytes: 3000000},
+		{Family: "bridge", Table: "filter", Name: "dropped", Packets: 2, Bytes: 128},
+	}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("want %+v, got %+v", want, counters)
+	}
+}
+
+func TestFirewallIptablesFallback(t *testing.T) {
+	*firewallFixtures = "fixtures/firewall/iptables"
+	defer func() { *firewallFixtures = "" }()
+
+	collector, err := NewFirewallCollector(log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, counters, err := collector.(*firewallCollector).firewallCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters != nil {
+		t.Errorf("want no named counters from iptables, got %+v", counters)
+	}
+	families := map[string]int{}
+	for _, r := range rules {
+		families[r.Family]++
+	}
+	if families["ip"] == 0 || families["ip6"] == 0 {
+		t.Errorf("want rules of iptables-save and ip6tables-save, got %+v", rules)
+	}
+
+	ch := make(chan prometheus.Metric, 2*len(rules))
+	if err := collector.Update(ch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadNetlinkFixture(t *testing.T) {
+	msgs, err := readNetlinkFixture("fixtures/firewall/nftables-rules.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testNftRuleMessages(t); !reflect.DeepEqual(msgs, want) {
+		t.Errorf("want %+v, got %+v", want, msgs)
+	}
+}
+
+// testNftRuleMessages are NFT_MSG_NEWRULE messages as dumped by the kernel.
+func testNftRuleMessages(t *testing.T) []netlink.Message {
+	return []netlink.Message{
+		nftMessage(t, 6, unix.NFPROTO_INET, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaRuleTable, "filter")
+			ae.String(nftaRuleChain, "input")
+			ae.Uint64(nftaRuleHandle, 4)
+			nftExpressions(ae, func(ae *netlink.AttributeEncoder) {
+				nftExpression(ae, "payload", func(ae *netlink.AttributeEncoder) {
+					ae.Uint32(1, 1)
+				})
+				nftCounterExpression(ae, 1024, 65536)
+			})
+			ae.Bytes(nftaRuleUserdata, append([]byte{nftnlUdataRuleComment, 10}, "allow ssh\x00"...))
+		}),
+		nftMessage(t, 6, unix.NFPROTO_INET, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaRuleTable, "filter")
+			ae.String(nftaRuleChain, "input")
+			ae.Uint64(nftaRuleHandle, 5)
+			nftExpressions(ae, func(ae *netlink.AttributeEncoder) {
+				nftCounterExpression(ae, 10, 840)
+			})
+		}),
+		// Rules without a counter are skipped.
+		nftMessage(t, 6, unix.NFPROTO_INET, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaRuleTable, "filter")
+			ae.String(nftaRuleChain, "input")
+			ae.Uint64(nftaRuleHandle, 6)
+			nftExpressions(ae, func(ae *netlink.AttributeEncoder) {
+				nftExpression(ae, "immediate", func(ae *netlink.AttributeEncoder) {
+					ae.Uint32(1, 0)
+				})
+			})
+		}),
+		// Rules added by iptables-nft have their comment in a match.
+		nftMessage(t, 6, unix.NFPROTO_IPV4, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaRuleTable, "filter")
+			ae.String(nftaRuleChain, "INPUT")
+			ae.Uint64(nftaRuleHandle, 7)
+			nftExpressions(ae, func(ae *netlink.AttributeEncoder) {
+				nftExpression(ae, "match", func(ae *netlink.AttributeEncoder) {
+					ae.String(nftaMatchName, "comment")
+					ae.Uint32(2, 0)
+					info := make([]byte, 256)
+					copy(info, "iptables-nft rule")
+					ae.Bytes(nftaMatchInfo, info)
+				})
+				nftCounterExpression(ae, 3, 180)
+			})
+		}),
+		nftMessage(t, 6, unix.NFPROTO_IPV6, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaRuleTable, "filter")
+			ae.String(nftaRuleChain, "forward")
+			ae.Uint64(nftaRuleHandle, 2)
+			nftExpressions(ae, func(ae *netlink.AttributeEncoder) {
+				nftCounterExpression(ae, 0, 0)
+			})
+		}),
+	}
+}
+
+// testNftObjectMessages are NFT_MSG_NEWOBJ messages as dumped by the kernel.
+func testNftObjectMessages(t *testing.T) []netlink.Message {
+	return []netlink.Message{
+		nftMessage(t, 18, unix.NFPROTO_INET, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaObjTable, "filter")
+			ae.String(nftaObjName, "http")
+			ae.Uint32(nftaObjType, nftObjectCounter)
+			ae.Nested(nftaObjData, func(ae *netlink.AttributeEncoder) error {
+				ae.Uint64(nftaCounterBytes, 3000000)
+				ae.Uint64(nftaCounterPackets, 5000)
+				return nil
+			})
+		}),
+		// Quotas are skipped.
+		nftMessage(t, 18, unix.NFPROTO_INET, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaObjTable, "filter")
+			ae.String(nftaObjName, "monthly")
+			ae.Uint32(nftaObjType, 2)
+			ae.Nested(nftaObjData, func(ae *netlink.AttributeEncoder) error {
+				ae.Uint64(1, 1<<30)
+				ae.Uint64(2, 4096)
+				return nil
+			})
+		}),
+		nftMessage(t, 18, unix.NFPROTO_BRIDGE, func(ae *netlink.AttributeEncoder) {
+			ae.String(nftaObjTable, "filter")
+			ae.String(nftaObjName, "dropped")
+			ae.Uint32(nftaObjType, nftObjectCounter)
+			ae.Nested(nftaObjData, func(ae *netlink.AttributeEncoder) error {
+				ae.Uint64(nftaCounterBytes, 128)
+				ae.Uint64(nftaCounterPackets, 2)
+				return nil
+			})
+		}),
+	}
+}
+
+func nftMessage(t *testing.T, msgType uint16, family uint8, fn func(ae *netlink.AttributeEncoder)) netlink.Message {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	fn(ae)
+	attrs, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{family, unix.NFNETLINK_V0, 0, 0}, attrs...)
+	return netlink.Message{
+		Header: netlink.Header{
+			Length: uint32(16 + len(data)),
+			Type:   netlink.HeaderType(nfnlSubsysNftables<<8 | msgType),
+			Flags:  netlink.Multi,
+		},
+		Data: data,
+	}
+}
+
+func nftExpressions(ae *netlink.AttributeEncoder, fn func(ae *netlink.AttributeEncoder)) {
+	ae.Nested(nftaRuleExpressions, func(ae *netlink.AttributeEncoder) error {
+		fn(ae)
+		return nil
+	})
+}
+
+func nftExpression(ae *netlink.AttributeEncoder, name string, fn func(ae *netlink.AttributeEncoder)) {
+	ae.Nested(nftaListElem, func(ae *netlink.AttributeEncoder) error {
+		ae.String(nftaExprName, name)
+		ae.Nested(nftaExprData, func(ae *netlink.AttributeEncoder) error {
+			fn(ae)
+			return nil
+		})
+		return nil
+	})
+}
+
+func nftCounterExpression(ae *netlink.AttributeEncoder, packets, bytes uint64) {
+	nftExpression(ae, "counter", func(ae *netlink.AttributeEncoder) {
+		ae.Uint64(nftaCounterBytes, bytes)
+		ae.Uint64(nftaCounterPackets, packets)
+	})
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nofirewall
+
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// nftables message types and attributes, see
+// include/uapi/linux/netfilter/nf_tables.h.
+const (
+	nfnlSubsysNftables = 10
+	nftMsgGetRule      = 7
+	nftMsgGetObj       = 19
+
+	nftaRuleTable       = 1
+	nftaRuleChain       = 2
+	nftaRuleHandle      = 3
+	nftaRuleExpressions = 4
+	nftaRuleUserdata    = 7
+
+	nftaListElem = 1
+
+	nftaExprName = 1
+	nftaExprData = 2
+
+	nftaCounterBytes   = 1
+	nftaCounterPackets = 2
+
+	nftaMatchName = 1
+	nftaMatchInfo = 3
+
+	nftaObjTable = 1
+	nftaObjName  = 2
+	nftaObjType  = 3
+	nftaObjData  = 4
+
+	nftObjectCounter = 1
+
+	// Type of the comment in the user data of rules, as set by nft(8), see
+	// NFTNL_UDATA_RULE_COMMENT in libnftnl.
+	nftnlUdataRuleComment = 0
+)
+
+// nftFamilyNames are the names of the address families of tables, as used by
+// nft(8).
+var nftFamilyNames = map[uint8]string{
+	unix.NFPROTO_INET:   "inet",
+	unix.NFPROTO_IPV4:   "ip",
+	unix.NFPROTO_ARP:    "arp",
+	unix.NFPROTO_NETDEV: "netdev",
+	unix.NFPROTO_BRIDGE: "bridge",
+	unix.NFPROTO_IPV6:   "ip6",
+}
+
+// dumpNftables returns the rule and object messages of all nftables tables.
+func dumpNftables() (rules, objects []netlink.Message, err error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	dump := func(msgType uint16) ([]netlink.Message, error) {
+		return conn.Execute(netlink.Message{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType(nfnlSubsysNftables<<8 | msgType),
+				Flags: netlink.Request | netlink.Dump,
+			},
+			Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+		})
+	}
+	if rules, err = dump(nftMsgGetRule); err != nil {
+		return nil, nil, err
+	}
+	if objects, err = dump(nftMsgGetObj); err != nil {
+		return nil, nil, err
+	}
+	return rules, objects, nil
+}
+
+// parseNftRules parses NFT_MSG_NEWRULE messages. Rules without a counter are
+// skipped, and rules are identified by their comment if they have one, or else
+// by their handle.
+func parseNftRules(msgs []netlink.Message) ([]firewallRule, error) {
+	var rules []firewallRule
+	for _, m := range msgs {
+		if len(m.Data) < nfgenmsgLen {
+			return nil, fmt.Errorf("short nftables rule message of %d bytes", len(m.Data))
+		}
+		ad, err := netlink.NewAttributeDecoder(m.Data[nfgenmsgLen:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+
+		var (
+			rule       = firewallRule{Family: nftFamilyName(m.Data[0])}
+			hasCounter bool
+		)
+		for ad.Next() {
+			switch ad.Type() {
+			case nftaRuleTable:
+				rule.Table = ad.String()
+			case nftaRuleChain:
+				rule.Chain = ad.String()
+			case nftaRuleHandle:
+				if rule.Rule == "" {
+					rule.Rule = strconv.FormatUint(ad.Uint64(), 10)
+				}
+			case nftaRuleUserdata:
+				if comment := nftRuleComment(ad.Bytes()); comment != "" {
+					rule.Rule = comment
+				}
+			case nftaRuleExpressions:
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					for nad.Next() {
+						if nad.Type() != nftaListElem {
+							continue
+						}
+						nad.Nested(func(ead *netlink.AttributeDecoder) error {
+							var name string
+							for ead.Next() {
+								switch ead.Type() {
+								case nftaExprName:
+									name = ead.String()
+								case nftaExprData:
+									// The name precedes the data of an expression.
+									switch name {
+									case "counter":
+										if !hasCounter {
+											ead.Nested(func(cad *netlink.AttributeDecoder) error {
+												rule.Packets, rule.Bytes = nftCounterValues(cad)
+												return nil
+											})
+											hasCounter = true
+										}
+									case "match":
+										// Comments of rules added with iptables-nft are
+										// in the xtables comment match.
+										ead.Nested(func(mad *netlink.AttributeDecoder) error {
+											if comment := nftMatchComment(mad); comment != "" {
+												rule.Rule = comment
+											}
+											return nil
+										})
+									}
+								}
+							}
+							return nil
+						})
+					}
+					return nil
+				})
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if hasCounter {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// parseNftCounters parses NFT_MSG_NEWOBJ messages. Objects other than named
+// counters, e.g. quotas, are skipped.
+func parseNftCounters(msgs []netlink.Message) ([]firewallCounter, error) {
+	var counters []firewallCounter
+	for _, m := range msgs {
+		if len(m.Data) < nfgenmsgLen {
+			return nil, fmt.Errorf("short nftables object message of %d bytes", len(m.Data))
+		}
+		ad, err := netlink.NewAttributeDecoder(m.Data[nfgenmsgLen:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+
+		var (
+			counter = firewallCounter{Family: nftFamilyName(m.Data[0])}
+			objType uint32
+		)
+		for ad.Next() {
+			switch ad.Type() {
+			case nftaObjTable:
+				counter.Table = ad.String()
+			case nftaObjName:
+				counter.Name = ad.String()
+			case nftaObjType:
+				objType = ad.Uint32()
+			case nftaObjData:
+				// The type precedes the data of an object.
+				if objType == nftObjectCounter {
+					ad.Nested(func(nad *netlink.AttributeDecoder) error {
+						counter.Packets, counter.Bytes = nftCounterValues(nad)
+						return nil
+					})
+				}
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if objType == nftObjectCounter {
+			counters = append(counters, counter)
+		}
+	}
+	return counters, nil
+}
+
+func nftCounterValues(ad *netlink.AttributeDecoder) (uint64, uint64) {
+	var packets, bytes uint64
+	for ad.Next() {
+		switch ad.Type() {
+		case nftaCounterPackets:
+			packets = ad.Uint64()
+		case nftaCounterBytes:
+			bytes = ad.Uint64()
+		}
+	}
+	return packets, bytes
+}
+
+// nftRuleComment returns the comment in the user data of a rule, which is a
+// list of type, length and value triplets of which the comment is a NUL
+// terminated string.
+func nftRuleComment(b []byte) string {
+	for len(b) >= 2 {
+		typ, size := b[0], int(b[1])
+		if 2+size > len(b) {
+			break
+		}
+		if typ == nftnlUdataRuleComment {
+			return bytesToString(b[2 : 2+size])
+		}
+		b = b[2+size:]
+	}
+	return ""
+}
+
+// nftMatchComment returns the comment of an xtables comment match, whose info
+// is a struct xt_comment_info.
+func nftMatchComment(ad *netlink.AttributeDecoder) string {
+	var name string
+	for ad.Next() {
+		switch ad.Type() {
+		case nftaMatchName:
+			name = ad.String()
+		case nftaMatchInfo:
+			if name == "comment" {
+				return bytesToString(ad.Bytes())
+			}
+		}
+	}
+	return ""
+}
+
+func nftFamilyName(family uint8) string {
+	if name, ok := nftFamilyNames[family]; ok {
+		return name
+	}
+	return strconv.Itoa(int(family))
+}
@@ -15,7 +15,9 @@ package collector
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -44,3 +46,61 @@ func bytesToString(byteArray []byte) string {
 	}
 	return string(byteArray[:n])
 }
+
+// parseBuckets parses a comma separated list of increasing bucket bounds.
+func parseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("bucket bounds must be increasing, got %v after %v", b, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// histogram is a minimal histogram with fixed bucket bounds.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.observeN(v, 1)
+}
+
+// observeN observes n values, with v being their average.
+func (h *histogram) observeN(v float64, n uint64) {
+	h.count += n
+	h.sum += v * float64(n)
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i] += n
+	}
+}
+
+// cumulative returns the cumulative bucket counts.
+func (h *histogram) cumulative() map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(h.buckets))
+	var n uint64
+	for i, b := range h.buckets {
+		n += h.counts[i]
+		buckets[b] = n
+	}
+	return buckets
+}
+
+// unescapeMountPoint translates the octal escapes of mount points, see fstab(5).
+func unescapeMountPoint(s string) string {
+	s = strings.Replace(s, "\\040", " ", -1)
+	return strings.Replace(s, "\\011", "\t", -1)
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mdlayher/netlink"
+)
+
+// nfgenmsgLen is the size of struct nfgenmsg, which precedes the attributes of
+// netfilter netlink messages.
+const nfgenmsgLen = 4
+
+// readNetlinkFixture reads recorded netlink messages from a file with one
+// hex encoded message per line. Empty lines and lines starting with # are
+// ignored.
+func readNetlinkFixture(path string) ([]netlink.Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var msgs []netlink.Message
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid netlink message in %s: %w", path, err)
+		}
+		var m netlink.Message
+		if err := m.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("invalid netlink message in %s: %w", path, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, scanner.Err()
+}
@@ -14,6 +14,7 @@
 package collector
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -61,3 +62,32 @@ func TestBytesToString(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := parseBuckets("0.001, 0.01,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0.001, 0.01, 1}; !reflect.DeepEqual(buckets, want) {
+		t.Errorf("want %v, got %v", want, buckets)
+	}
+	for _, s := range []string{"", "1,1", "2,1", "1,x"} {
+		if _, err := parseBuckets(s); err == nil {
+			t.Errorf("%q: expected error, got none", s)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4})
+	h.observe(0.5)
+	h.observeN(3, 4)
+	h.observe(8)
+
+	if want := map[float64]uint64{1: 1, 2: 1, 4: 5}; !reflect.DeepEqual(h.cumulative(), want) {
+		t.Errorf("want buckets %v, got %v", want, h.cumulative())
+	}
+	if h.count != 6 || h.sum != 20.5 {
+		t.Errorf("want count 6 and sum 20.5, got %d and %v", h.count, h.sum)
+	}
+}
@@ -20,15 +20,46 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	interruptsAggregation = kingpin.Flag("collector.interrupts.aggregation", "Expose interrupts and softirqs per CPU (cpu) or summed over all CPUs (sum).").Default("cpu").Enum("cpu", "sum")
+	interruptsTopN        = kingpin.Flag("collector.interrupts.top-n", "Only expose the N interrupts with the most occurrences since boot, 0 exposes all.").Default("0").Int()
 )
 
 var (
 	interruptLabelNames = []string{"cpu", "type", "info", "devices"}
+
+	interruptsSumDesc = typedDesc{prometheus.NewDesc(
+		namespace+"_interrupts_total",
+		"Interrupt details.",
+		[]string{"type", "info", "devices"}, nil,
+	), prometheus.CounterValue}
+	interruptsAffinityDesc = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "interrupts", "affinity_info"),
+		"CPUs an interrupt may be handled by, from /proc/irq/<irq>/smp_affinity_list.",
+		[]string{"type", "devices", "cpus"}, nil,
+	), prometheus.GaugeValue}
+	softirqsDesc = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "interrupts", "softirqs_total"),
+		"Softirq details.",
+		[]string{"cpu", "type"}, nil,
+	), prometheus.CounterValue}
+	softirqsSumDesc = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "interrupts", "softirqs_total"),
+		"Softirq details.",
+		[]string{"type"}, nil,
+	), prometheus.CounterValue}
 )
 
 func (c *interruptsCollector) Update(ch chan<- prometheus.Metric) (err error) {
@@ -36,18 +67,85 @@ func (c *interruptsCollector) Update(ch chan<- prometheus.Metric) (err error) {
 	if err != nil {
 		return fmt.Errorf("couldn't get interrupts: %w", err)
 	}
+	counts := make(map[string][]float64, len(interrupts))
 	for name, interrupt := range interrupts {
-		for cpuNo, value := range interrupt.values {
+		for _, value := range interrupt.values {
 			fv, err := strconv.ParseFloat(value, 64)
 			if err != nil {
 				return fmt.Errorf("invalid value %s in interrupts: %w", value, err)
 			}
-			ch <- c.desc.mustNewConstMetric(fv, strconv.Itoa(cpuNo), name, interrupt.info, interrupt.devices)
+			counts[name] = append(counts[name], fv)
+		}
+	}
+
+	for _, name := range topInterrupts(counts, *interruptsTopN) {
+		interrupt := interrupts[name]
+		if *interruptsAggregation == "sum" {
+			ch <- interruptsSumDesc.mustNewConstMetric(sumValues(counts[name]), name, interrupt.info, interrupt.devices)
+		} else {
+			for cpuNo, v := range counts[name] {
+				ch <- c.desc.mustNewConstMetric(v, strconv.Itoa(cpuNo), name, interrupt.info, interrupt.devices)
+			}
+		}
+
+		// Only numbered interrupts have an affinity.
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+		affinity, err := ioutil.ReadFile(procFilePath(filepath.Join("irq", name, "smp_affinity_list")))
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read interrupt affinity", "irq", name, "err", err)
+			continue
+		}
+		ch <- interruptsAffinityDesc.mustNewConstMetric(1, name, interrupt.devices, strings.TrimSpace(string(affinity)))
+	}
+
+	softirqs, err := getSoftirqs()
+	if err != nil {
+		return fmt.Errorf("couldn't get softirqs: %w", err)
+	}
+	for name, values := range softirqs {
+		name = strings.ToLower(name)
+		if *interruptsAggregation == "sum" {
+			ch <- softirqsSumDesc.mustNewConstMetric(sumValues(values), name)
+		} else {
+			for cpuNo, v := range values {
+				ch <- softirqsDesc.mustNewConstMetric(v, strconv.Itoa(cpuNo), name)
+			}
 		}
 	}
 	return err
 }
 
+func sumValues(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// topInterrupts returns the names of the n interrupts with the most
+// occurrences over all CPUs, or of all interrupts if n is 0.
+func topInterrupts(counts map[string][]float64, n int) []string {
+	names := make([]string, 0, len(counts))
+	totals := make(map[string]float64, len(counts))
+	for name, values := range counts {
+		names = append(names, name)
+		totals[name] = sumValues(values)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	return names
+}
+
 type interrupt struct {
 	info    string
 	devices string
@@ -96,3 +194,41 @@ func parseInterrupts(r io.Reader) (map[string]interrupt, error) {
 
 	return interrupts, scanner.Err()
 }
+
+func getSoftirqs() (map[string][]float64, error) {
+	file, err := os.Open(procFilePath("softirqs"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseSoftirqs(file)
+}
+
+// parseSoftirqs parses /proc/softirqs, which has a header of CPUs followed by
+// a line of counts per CPU for each softirq type.
+func parseSoftirqs(r io.Reader) (map[string][]float64, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, errors.New("softirqs empty")
+	}
+	cpuNum := len(strings.Fields(scanner.Text()))
+
+	softirqs := map[string][]float64{}
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != cpuNum+1 {
+			return nil, fmt.Errorf("invalid softirqs line %q, want %d fields", scanner.Text(), cpuNum+1)
+		}
+		values := make([]float64, cpuNum)
+		for i, part := range parts[1:] {
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s in softirqs: %w", part, err)
+			}
+			values[i] = v
+		}
+		softirqs[strings.TrimSuffix(parts[0], ":")] = values
+	}
+	return softirqs, scanner.Err()
+}
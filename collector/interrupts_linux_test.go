@@ -15,6 +15,7 @@ package collector
 
 import (
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -38,3 +39,39 @@ func TestInterrupts(t *testing.T) {
 		t.Errorf("want interrupts %s, got %s", want, got)
 	}
 }
+
+func TestSoftirqs(t *testing.T) {
+	file, err := os.Open("fixtures/proc/softirqs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	softirqs, err := parseSoftirqs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 10, len(softirqs); want != got {
+		t.Errorf("want %d softirq types, got %d", want, got)
+	}
+	if want, got := []float64{829561, 165731, 168893, 189902}, softirqs["NET_RX"]; !reflect.DeepEqual(want, got) {
+		t.Errorf("want NET_RX softirqs %v, got %v", want, got)
+	}
+}
+
+func TestTopInterrupts(t *testing.T) {
+	counts := map[string][]float64{
+		"0":   {18, 0},
+		"43":  {7434032, 8092205},
+		"LOC": {174326351, 135776678},
+		"SPU": {0, 0},
+		"ERR": {0, 0},
+	}
+	if want, got := []string{"LOC", "43"}, topInterrupts(counts, 2); !reflect.DeepEqual(want, got) {
+		t.Errorf("want top interrupts %v, got %v", want, got)
+	}
+	if want, got := []string{"LOC", "43", "0", "ERR", "SPU"}, topInterrupts(counts, 0); !reflect.DeepEqual(want, got) {
+		t.Errorf("want all interrupts %v, got %v", want, got)
+	}
+}
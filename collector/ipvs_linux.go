@@ -16,9 +16,11 @@
 package collector
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -36,6 +38,9 @@ type ipvsCollector struct {
 	backendLabels                                                               []string
 	backendConnectionsActive, backendConnectionsInact, backendWeight            typedDesc
 	connections, incomingPackets, outgoingPackets, incomingBytes, outgoingBytes typedDesc
+	connectionTableSize                                                         typedDesc
+	serviceInfo, backendInfo, backendUpperThreshold, backendLowerThreshold      typedDesc
+	syncDaemonInfo                                                              typedDesc
 	logger                                                                      log.Logger
 }
 
@@ -63,7 +68,11 @@ var (
 		ipvsLabelProto,
 		ipvsLabelLocalMark,
 	}
-	ipvsLabels = kingpin.Flag("collector.ipvs.backend-labels", "Comma separated list for IPVS backend stats labels.").Default(strings.Join(fullIpvsBackendLabels, ",")).String()
+	ipvsLabels          = kingpin.Flag("collector.ipvs.backend-labels", "Comma separated list for IPVS backend stats labels.").Default(strings.Join(fullIpvsBackendLabels, ",")).String()
+	ipvsNetlinkEnabled  = kingpin.Flag("collector.ipvs.netlink", "Enables metrics of the virtual service and real server configuration and of the sync daemons via generic netlink, which requires CAP_NET_ADMIN.").Bool()
+	ipvsNetlinkFixtures = kingpin.Flag("collector.ipvs.netlink-fixtures", "test fixtures to use for ipvs collector netlink metrics").Default("").Hidden().String()
+
+	ipvsConnectionTableSizeRE = regexp.MustCompile(`\(size=(\d+)\)`)
 )
 
 func init() {
@@ -133,6 +142,36 @@ func newIPVSCollector(logger log.Logger) (*ipvsCollector, error) {
 		"The current backend weight by local and remote address.",
 		c.backendLabels, nil,
 	), prometheus.GaugeValue}
+	c.connectionTableSize = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "connection_table_size"),
+		"The size of the connection hash table.",
+		nil, nil,
+	), prometheus.GaugeValue}
+	c.serviceInfo = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "service_info"),
+		"The scheduler and flags of a virtual service, with a value of 1.",
+		[]string{ipvsLabelLocalAddress, ipvsLabelLocalPort, ipvsLabelProto, ipvsLabelLocalMark, "scheduler", "flags"}, nil,
+	), prometheus.GaugeValue}
+	c.backendInfo = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "backend_info"),
+		"The forwarding method of a backend, with a value of 1.",
+		append(c.backendLabels[:len(c.backendLabels):len(c.backendLabels)], "forward_method"), nil,
+	), prometheus.GaugeValue}
+	c.backendUpperThreshold = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "backend_upper_threshold"),
+		"The connection upper threshold of a backend, 0 if unlimited.",
+		c.backendLabels, nil,
+	), prometheus.GaugeValue}
+	c.backendLowerThreshold = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "backend_lower_threshold"),
+		"The connection lower threshold of a backend.",
+		c.backendLabels, nil,
+	), prometheus.GaugeValue}
+	c.syncDaemonInfo = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, subsystem, "sync_daemon_info"),
+		"The state, multicast interface and sync ID of a running connection synchronization daemon, with a value of 1.",
+		[]string{"state", "interface", "sync_id"}, nil,
+	), prometheus.GaugeValue}
 
 	return &c, nil
 }
@@ -165,25 +204,7 @@ func (c *ipvsCollector) Update(ch chan<- prometheus.Metric) error {
 		if backend.LocalAddress.String() != "<nil>" {
 			localAddress = backend.LocalAddress.String()
 		}
-		kv := make([]string, len(c.backendLabels))
-		for i, label := range c.backendLabels {
-			var labelValue string
-			switch label {
-			case ipvsLabelLocalAddress:
-				labelValue = localAddress
-			case ipvsLabelLocalPort:
-				labelValue = strconv.FormatUint(uint64(backend.LocalPort), 10)
-			case ipvsLabelRemoteAddress:
-				labelValue = backend.RemoteAddress.String()
-			case ipvsLabelRemotePort:
-				labelValue = strconv.FormatUint(uint64(backend.RemotePort), 10)
-			case ipvsLabelProto:
-				labelValue = backend.Proto
-			case ipvsLabelLocalMark:
-				labelValue = backend.LocalMark
-			}
-			kv[i] = labelValue
-		}
+		kv := c.backendLabelValues(localAddress, strconv.FormatUint(uint64(backend.LocalPort), 10), backend.RemoteAddress.String(), strconv.FormatUint(uint64(backend.RemotePort), 10), backend.Proto, backend.LocalMark)
 		key := strings.Join(kv, "-")
 		status := sums[key]
 		status.ActiveConn += backend.ActiveConn
@@ -198,9 +219,111 @@ func (c *ipvsCollector) Update(ch chan<- prometheus.Metric) error {
 		ch <- c.backendConnectionsInact.mustNewConstMetric(float64(status.InactConn), kv...)
 		ch <- c.backendWeight.mustNewConstMetric(float64(status.Weight), kv...)
 	}
+
+	if size, err := readIPVSConnectionTableSize(procFilePath("net/ip_vs")); err != nil {
+		level.Debug(c.logger).Log("msg", "failed to read IPVS connection table size", "err", err)
+	} else {
+		ch <- c.connectionTableSize.mustNewConstMetric(float64(size))
+	}
+
+	if !*ipvsNetlinkEnabled {
+		return nil
+	}
+	return c.updateNetlink(ch)
+}
+
+// updateNetlink exposes the virtual service and real server configuration and
+// the sync daemons, which are not shown in /proc/net/ip_vs.
+func (c *ipvsCollector) updateNetlink(ch chan<- prometheus.Metric) error {
+	stat, err := newIPVSStater(*ipvsNetlinkFixtures)
+	if err != nil {
+		return fmt.Errorf("failed to access IPVS via generic netlink: %w", err)
+	}
+	defer stat.Close()
+
+	services, err := stat.Services()
+	if err != nil {
+		return fmt.Errorf("failed to get IPVS services: %w", err)
+	}
+	// Backend info is deduplicated by the configured backend labels. The
+	// thresholds are limits, with 0 meaning unlimited, which can't be
+	// aggregated, so they are only exposed if the labels identify a backend.
+	perDestination := len(c.backendLabels) == len(fullIpvsBackendLabels)
+	infoLabelValues := map[string][]string{}
+	for _, svc := range services {
+		localPort := strconv.FormatUint(uint64(svc.LocalPort), 10)
+		ch <- c.serviceInfo.mustNewConstMetric(1, svc.LocalAddress, localPort, svc.Proto, svc.LocalMark, svc.Scheduler, strings.Join(svc.Flags, ","))
+		for _, dest := range svc.Destinations {
+			kv := c.backendLabelValues(svc.LocalAddress, localPort, dest.RemoteAddress, strconv.FormatUint(uint64(dest.RemotePort), 10), svc.Proto, svc.LocalMark)
+			if perDestination {
+				ch <- c.backendUpperThreshold.mustNewConstMetric(float64(dest.UpperThreshold), kv...)
+				ch <- c.backendLowerThreshold.mustNewConstMetric(float64(dest.LowerThreshold), kv...)
+			}
+
+			infoKV := append(kv[:len(kv):len(kv)], dest.ForwardMethod)
+			infoLabelValues[strings.Join(infoKV, "-")] = infoKV
+		}
+	}
+	for _, kv := range infoLabelValues {
+		ch <- c.backendInfo.mustNewConstMetric(1, kv...)
+	}
+
+	daemons, err := stat.Daemons()
+	if err != nil {
+		return fmt.Errorf("failed to get IPVS sync daemons: %w", err)
+	}
+	for _, daemon := range daemons {
+		ch <- c.syncDaemonInfo.mustNewConstMetric(1, daemon.State, daemon.Interface, strconv.FormatUint(uint64(daemon.SyncID), 10))
+	}
 	return nil
 }
 
+// backendLabelValues returns the values of the configured backend labels for a
+// backend.
+func (c *ipvsCollector) backendLabelValues(localAddress, localPort, remoteAddress, remotePort, proto, localMark string) []string {
+	kv := make([]string, len(c.backendLabels))
+	for i, label := range c.backendLabels {
+		switch label {
+		case ipvsLabelLocalAddress:
+			kv[i] = localAddress
+		case ipvsLabelLocalPort:
+			kv[i] = localPort
+		case ipvsLabelRemoteAddress:
+			kv[i] = remoteAddress
+		case ipvsLabelRemotePort:
+			kv[i] = remotePort
+		case ipvsLabelProto:
+			kv[i] = proto
+		case ipvsLabelLocalMark:
+			kv[i] = localMark
+		}
+	}
+	return kv
+}
+
+// readIPVSConnectionTableSize reads the size of the connection hash table from
+// the header of /proc/net/ip_vs.
+func readIPVSConnectionTableSize(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("empty IPVS table")
+	}
+	match := ipvsConnectionTableSizeRE.FindStringSubmatch(scanner.Text())
+	if match == nil {
+		return 0, fmt.Errorf("no connection table size in %q", scanner.Text())
+	}
+	return strconv.ParseUint(match[1], 10, 64)
+}
+
 func (c *ipvsCollector) parseIpvsLabels(labelString string) ([]string, error) {
 	labels := strings.Split(labelString, ",")
 	labelSet := make(map[string]bool, len(labels))
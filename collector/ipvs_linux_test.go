@@ -15,14 +15,18 @@ package collector
 
 import (
 	"errors"
-	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -46,6 +50,7 @@ func TestIPVSCollector(t *testing.T) {
 				prometheus.NewDesc("node_ipvs_backend_connections_active", "The current active connections by local and remote address.", []string{"local_address", "local_port", "remote_address", "remote_port", "proto", "local_mark"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_connections_inactive", "The current inactive connections by local and remote address.", []string{"local_address", "local_port", "remote_address", "remote_port", "proto", "local_mark"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_weight", "The current backend weight by local and remote address.", []string{"local_address", "local_port", "remote_address", "remote_port", "proto", "local_mark"}, nil).String(),
+				prometheus.NewDesc("node_ipvs_connection_table_size", "The size of the connection hash table.", nil, nil).String(),
 			},
 			nil,
 		},
@@ -60,6 +65,7 @@ func TestIPVSCollector(t *testing.T) {
 				prometheus.NewDesc("node_ipvs_backend_connections_active", "The current active connections by local and remote address.", nil, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_connections_inactive", "The current inactive connections by local and remote address.", nil, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_weight", "The current backend weight by local and remote address.", nil, nil).String(),
+				prometheus.NewDesc("node_ipvs_connection_table_size", "The size of the connection hash table.", nil, nil).String(),
 			},
 			nil,
 		},
@@ -74,6 +80,7 @@ func TestIPVSCollector(t *testing.T) {
 				prometheus.NewDesc("node_ipvs_backend_connections_active", "The current active connections by local and remote address.", []string{"local_port"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_connections_inactive", "The current inactive connections by local and remote address.", []string{"local_port"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_weight", "The current backend weight by local and remote address.", []string{"local_port"}, nil).String(),
+				prometheus.NewDesc("node_ipvs_connection_table_size", "The size of the connection hash table.", nil, nil).String(),
 			},
 			nil,
 		},
@@ -88,6 +95,7 @@ func TestIPVSCollector(t *testing.T) {
 				prometheus.NewDesc("node_ipvs_backend_connections_active", "The current active connections by local and remote address.", []string{"local_address", "local_port"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_connections_inactive", "The current inactive connections by local and remote address.", []string{"local_address", "local_port"}, nil).String(),
 				prometheus.NewDesc("node_ipvs_backend_weight", "The current backend weight by local and remote address.", []string{"local_address", "local_port"}, nil).String(),
+				prometheus.NewDesc("node_ipvs_connection_table_size", "The size of the connection hash table.", nil, nil).String(),
 			},
 			nil,
 		},
@@ -128,16 +136,25 @@ func TestIPVSCollector(t *testing.T) {
 			sink := make(chan prometheus.Metric)
 			go func() {
 				err = collector.Update(sink)
-				if err != nil {
-					panic(fmt.Sprintf("failed to update collector: %v", err))
-				}
+				close(sink)
 			}()
-			for _, expected := range test.expects {
-				got := (<-sink).Desc().String()
-				if expected != got {
-					t.Fatalf("Expected '%s' but got '%s'", expected, got)
+			// Each backend has its own metrics, so compare the descs in the
+			// order they are first seen.
+			var got []string
+			seen := map[string]bool{}
+			for metric := range sink {
+				desc := metric.Desc().String()
+				if !seen[desc] {
+					seen[desc] = true
+					got = append(got, desc)
 				}
 			}
+			if err != nil {
+				t.Fatalf("failed to update collector: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expects) {
+				t.Fatalf("want descs %q, got %q", test.expects, got)
+			}
 		})
 	}
 }
@@ -219,3 +236,222 @@ func TestIPVSCollectorResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestReadIPVSConnectionTableSize(t *testing.T) {
+	size, err := readIPVSConnectionTableSize("fixtures/proc/net/ip_vs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(4096); size != want {
+		t.Errorf("want connection table size %d, got %d", want, size)
+	}
+}
+
+// ipvsAttributes encodes the attributes set by fn nested in an attribute of
+// the given type, as in the messages of the IPVS generic netlink family.
+func ipvsAttributes(t *testing.T, typ uint16, fn func(ae *netlink.AttributeEncoder)) []byte {
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(typ, func(nae *netlink.AttributeEncoder) error {
+		fn(nae)
+		return nil
+	})
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseIPVSService(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs func(ae *netlink.AttributeEncoder)
+		want  ipvsService
+	}{
+		{
+			name: "tcp",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Uint16(ipvsSvcAttrAF, unix.AF_INET)
+				ae.Uint16(ipvsSvcAttrProtocol, unix.IPPROTO_TCP)
+				ae.Bytes(ipvsSvcAttrAddr, net.ParseIP("192.168.0.22").To16()[12:])
+				ae.Bytes(ipvsSvcAttrPort, []byte{0x0c, 0xea})
+				ae.String(ipvsSvcAttrSchedName, "wlc")
+				ae.Bytes(ipvsSvcAttrFlags, append(nlenc.Uint32Bytes(0x3), nlenc.Uint32Bytes(0xffffffff)...))
+			},
+			want: ipvsService{
+				LocalAddress: "192.168.0.22",
+				LocalPort:    3306,
+				Proto:        "TCP",
+				Scheduler:    "wlc",
+				Flags:        []string{"persistent", "hashed"},
+			},
+		},
+		{
+			name: "ipv6 udp",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Uint16(ipvsSvcAttrAF, unix.AF_INET6)
+				ae.Uint16(ipvsSvcAttrProtocol, unix.IPPROTO_UDP)
+				ae.Bytes(ipvsSvcAttrAddr, net.ParseIP("2001:db8::1"))
+				ae.Bytes(ipvsSvcAttrPort, []byte{0x00, 0x35})
+				ae.String(ipvsSvcAttrSchedName, "rr")
+			},
+			want: ipvsService{
+				LocalAddress: "2001:db8::1",
+				LocalPort:    53,
+				Proto:        "UDP",
+				Scheduler:    "rr",
+			},
+		},
+		{
+			name: "fwmark",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Uint16(ipvsSvcAttrAF, unix.AF_INET)
+				ae.Uint32(ipvsSvcAttrFWMark, 0x10001000)
+				ae.String(ipvsSvcAttrSchedName, "sh")
+				ae.Bytes(ipvsSvcAttrFlags, append(nlenc.Uint32Bytes(0x2), nlenc.Uint32Bytes(0xffffffff)...))
+			},
+			want: ipvsService{
+				Proto:     "FWM",
+				LocalMark: "10001000",
+				Scheduler: "sh",
+				Flags:     []string{"hashed"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc, err := parseIPVSService(ipvsAttributes(t, ipvsCmdAttrService, test.attrs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(svc.attrs) == 0 {
+				t.Error("want attributes identifying the service, got none")
+			}
+			svc.af, svc.attrs = 0, nil
+			if !reflect.DeepEqual(*svc, test.want) {
+				t.Errorf("want %+v, got %+v", test.want, *svc)
+			}
+		})
+	}
+
+	if _, err := parseIPVSService(nil); err == nil {
+		t.Error("want error for message without service, got none")
+	}
+}
+
+func TestParseIPVSDestination(t *testing.T) {
+	b := ipvsAttributes(t, ipvsCmdAttrDest, func(ae *netlink.AttributeEncoder) {
+		ae.Bytes(ipvsDestAttrAddr, net.ParseIP("192.168.82.21").To16()[12:])
+		ae.Bytes(ipvsDestAttrPort, []byte{0x0c, 0xea})
+		ae.Uint32(ipvsDestAttrFwdMethod, 2)
+		ae.Uint32(ipvsDestAttrUThresh, 2000)
+		ae.Uint32(ipvsDestAttrLThresh, 1500)
+	})
+	dest, err := parseIPVSDestination(b, unix.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ipvsDestination{
+		RemoteAddress:  "192.168.82.21",
+		RemotePort:     3306,
+		ForwardMethod:  "tunnel",
+		UpperThreshold: 2000,
+		LowerThreshold: 1500,
+	}
+	if !reflect.DeepEqual(*dest, want) {
+		t.Errorf("want %+v, got %+v", want, *dest)
+	}
+
+	// The address family of the destination takes precedence over the one of
+	// the service.
+	b = ipvsAttributes(t, ipvsCmdAttrDest, func(ae *netlink.AttributeEncoder) {
+		ae.Bytes(ipvsDestAttrAddr, net.ParseIP("2001:db8::2"))
+		ae.Bytes(ipvsDestAttrPort, []byte{0x00, 0x50})
+		ae.Uint32(ipvsDestAttrFwdMethod, 0)
+		ae.Uint16(ipvsDestAttrAddrFamily, unix.AF_INET6)
+	})
+	dest, err = parseIPVSDestination(b, unix.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = ipvsDestination{RemoteAddress: "2001:db8::2", RemotePort: 80, ForwardMethod: "masq"}
+	if !reflect.DeepEqual(*dest, want) {
+		t.Errorf("want %+v, got %+v", want, *dest)
+	}
+}
+
+func TestParseIPVSDaemon(t *testing.T) {
+	b := ipvsAttributes(t, ipvsCmdAttrDaemon, func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(ipvsDaemonAttrState, 2)
+		ae.String(ipvsDaemonAttrMcastIfn, "eth1")
+		ae.Uint32(ipvsDaemonAttrSyncID, 7)
+	})
+	daemon, err := parseIPVSDaemon(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ipvsDaemon{State: "backup", Interface: "eth1", SyncID: 7}
+	if !reflect.DeepEqual(*daemon, want) {
+		t.Errorf("want %+v, got %+v", want, *daemon)
+	}
+}
+
+func TestIPVSCollectorNetlinkBackendLabels(t *testing.T) {
+	args := []string{
+		"--path.procfs", "fixtures/proc",
+		"--collector.ipvs.backend-labels=local_address,local_port",
+		"--collector.ipvs.netlink",
+		"--collector.ipvs.netlink-fixtures=fixtures/ipvs/",
+	}
+	if _, err := kingpin.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { *ipvsNetlinkEnabled = false }()
+	collector, err := NewIPVSCollector(log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(miniCollector{c: collector})
+
+	// Gathering fails if the backend info sharing the reduced label set is
+	// not deduplicated.
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := []string{}
+			for _, pair := range metric.GetLabel() {
+				labels = append(labels, pair.GetName()+"="+pair.GetValue())
+			}
+			got[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = metric.GetGauge().GetValue()
+		}
+	}
+	want := map[string]float64{
+		"node_ipvs_backend_info{forward_method=tunnel,local_address=,local_port=0}":                1,
+		"node_ipvs_backend_info{forward_method=tunnel,local_address=192.168.0.22,local_port=3306}": 1,
+		"node_ipvs_backend_info{forward_method=tunnel,local_address=192.168.0.55,local_port=3306}": 1,
+		"node_ipvs_backend_info{forward_method=tunnel,local_address=192.168.0.57,local_port=3306}": 1,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("%s: want %v, got %v (present: %t)", name, value, v, ok)
+		}
+	}
+	infos := 0
+	for name := range got {
+		if strings.HasPrefix(name, "node_ipvs_backend_info{") {
+			infos++
+		}
+		// Thresholds are not aggregated over backends.
+		if strings.Contains(name, "_threshold{") {
+			t.Errorf("want no thresholds without all backend labels, got %s", name)
+		}
+	}
+	if infos != 4 {
+		t.Errorf("want 4 backend info metrics, got %d", infos)
+	}
+}
@@ -0,0 +1,397 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noipvs
+
+package collector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// IPVS generic netlink commands and attributes, see
+// include/uapi/linux/ip_vs.h.
+const (
+	ipvsCmdGetService = 4
+	ipvsCmdGetDest    = 8
+	ipvsCmdGetDaemon  = 11
+
+	ipvsCmdAttrService = 1
+	ipvsCmdAttrDest    = 2
+	ipvsCmdAttrDaemon  = 3
+
+	ipvsSvcAttrAF        = 1
+	ipvsSvcAttrProtocol  = 2
+	ipvsSvcAttrAddr      = 3
+	ipvsSvcAttrPort      = 4
+	ipvsSvcAttrFWMark    = 5
+	ipvsSvcAttrSchedName = 6
+	ipvsSvcAttrFlags     = 7
+
+	ipvsDestAttrAddr       = 1
+	ipvsDestAttrPort       = 2
+	ipvsDestAttrFwdMethod  = 3
+	ipvsDestAttrUThresh    = 5
+	ipvsDestAttrLThresh    = 6
+	ipvsDestAttrAddrFamily = 11
+
+	ipvsDaemonAttrState    = 1
+	ipvsDaemonAttrMcastIfn = 2
+	ipvsDaemonAttrSyncID   = 3
+
+	ipvsConnFFwdMask = 0x7
+)
+
+var (
+	ipvsForwardMethods = []string{"masq", "local", "tunnel", "route", "bypass"}
+	ipvsServiceFlags   = []string{"persistent", "hashed", "onepacket", "sched-flag-1", "sched-flag-2", "sched-flag-3"}
+	ipvsDaemonStates   = map[uint32]string{1: "master", 2: "backup"}
+	ipvsProtocols      = map[uint16]string{unix.IPPROTO_TCP: "TCP", unix.IPPROTO_UDP: "UDP", unix.IPPROTO_SCTP: "SCTP"}
+)
+
+// ipvsService is an IPVS virtual service. LocalAddress and LocalPort are empty
+// and zero for firewall mark services, and LocalMark is empty otherwise,
+// as in /proc/net/ip_vs.
+type ipvsService struct {
+	LocalAddress string
+	LocalPort    uint16
+	Proto        string
+	LocalMark    string
+	Scheduler    string
+	Flags        []string
+	Destinations []ipvsDestination
+
+	// af and attrs identify the service in requests for its destinations.
+	af    uint16
+	attrs []byte
+}
+
+// ipvsDestination is a real server of an IPVS virtual service.
+type ipvsDestination struct {
+	RemoteAddress  string
+	RemotePort     uint16
+	ForwardMethod  string
+	UpperThreshold uint32
+	LowerThreshold uint32
+}
+
+// ipvsDaemon is a connection synchronization daemon.
+type ipvsDaemon struct {
+	State     string
+	Interface string
+	SyncID    uint32
+}
+
+// ipvsStater is an interface used to swap out the IPVS generic netlink family
+// for end to end tests.
+type ipvsStater interface {
+	Close() error
+	Services() ([]ipvsService, error)
+	Daemons() ([]ipvsDaemon, error)
+}
+
+// newIPVSStater determines if mocked test fixtures from files should be used
+// for the IPVS configuration, or if generic netlink should be used.
+func newIPVSStater(fixtures string) (ipvsStater, error) {
+	if fixtures != "" {
+		return &mockIPVSStater{fixtures: fixtures}, nil
+	}
+
+	conn, err := genetlink.Dial(nil)
+	if err != nil {
+		return nil, err
+	}
+	family, err := conn.GetFamily("IPVS")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &ipvsNetlink{conn: conn, family: family.ID}, nil
+}
+
+var _ ipvsStater = &ipvsNetlink{}
+
+type ipvsNetlink struct {
+	conn   *genetlink.Conn
+	family uint16
+}
+
+func (s *ipvsNetlink) Close() error { return s.conn.Close() }
+
+func (s *ipvsNetlink) dump(cmd uint8, attrs []byte) ([]genetlink.Message, error) {
+	return s.conn.Execute(genetlink.Message{
+		Header: genetlink.Header{Command: cmd, Version: 1},
+		Data:   attrs,
+	}, s.family, netlink.Request|netlink.Dump)
+}
+
+func (s *ipvsNetlink) Services() ([]ipvsService, error) {
+	msgs, err := s.dump(ipvsCmdGetService, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]ipvsService, 0, len(msgs))
+	for _, m := range msgs {
+		svc, err := parseIPVSService(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err := s.dump(ipvsCmdGetDest, svc.attrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			dest, err := parseIPVSDestination(m.Data, svc.af)
+			if err != nil {
+				return nil, err
+			}
+			svc.Destinations = append(svc.Destinations, *dest)
+		}
+		services = append(services, *svc)
+	}
+	return services, nil
+}
+
+func (s *ipvsNetlink) Daemons() ([]ipvsDaemon, error) {
+	msgs, err := s.dump(ipvsCmdGetDaemon, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var daemons []ipvsDaemon
+	for _, m := range msgs {
+		daemon, err := parseIPVSDaemon(m.Data)
+		if err != nil {
+			return nil, err
+		}
+		daemons = append(daemons, *daemon)
+	}
+	return daemons, nil
+}
+
+// parseIPVSService parses the attributes of an IPVS_CMD_NEW_SERVICE message.
+func parseIPVSService(b []byte) (*ipvsService, error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var svc ipvsService
+	for ad.Next() {
+		if ad.Type() != ipvsCmdAttrService {
+			continue
+		}
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			var (
+				af, protocol uint16
+				addr, port   []byte
+				fwmark       uint32
+			)
+			for nad.Next() {
+				switch nad.Type() {
+				case ipvsSvcAttrAF:
+					af = nad.Uint16()
+				case ipvsSvcAttrProtocol:
+					protocol = nad.Uint16()
+				case ipvsSvcAttrAddr:
+					addr = nad.Bytes()
+				case ipvsSvcAttrPort:
+					port = nad.Bytes()
+				case ipvsSvcAttrFWMark:
+					fwmark = nad.Uint32()
+				case ipvsSvcAttrSchedName:
+					svc.Scheduler = nad.String()
+				case ipvsSvcAttrFlags:
+					// struct ip_vs_flags has the flags followed by a mask.
+					if flags := nad.Bytes(); len(flags) >= 4 {
+						svc.Flags = ipvsFlagNames(nlenc.Uint32(flags[:4]))
+					}
+				}
+			}
+
+			svc.af = af
+			ae := netlink.NewAttributeEncoder()
+			ae.Uint16(ipvsSvcAttrAF, af)
+			if fwmark != 0 {
+				svc.Proto = "FWM"
+				svc.LocalMark = fmt.Sprintf("%08X", fwmark)
+				ae.Uint32(ipvsSvcAttrFWMark, fwmark)
+			} else {
+				svc.Proto = ipvsProtocols[protocol]
+				if svc.Proto == "" {
+					svc.Proto = fmt.Sprint(protocol)
+				}
+				svc.LocalAddress = ipvsAddress(af, addr)
+				if len(port) == 2 {
+					svc.LocalPort = binary.BigEndian.Uint16(port)
+				}
+				ae.Uint16(ipvsSvcAttrProtocol, protocol)
+				ae.Bytes(ipvsSvcAttrAddr, addr)
+				ae.Bytes(ipvsSvcAttrPort, port)
+			}
+			svc.attrs, err = ae.Encode()
+			return err
+		})
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	if svc.attrs == nil {
+		return nil, fmt.Errorf("IPVS service message without service")
+	}
+
+	// Nest the service attributes for requests.
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(ipvsCmdAttrService|unix.NLA_F_NESTED, svc.attrs)
+	svc.attrs, err = ae.Encode()
+	return &svc, err
+}
+
+// parseIPVSDestination parses the attributes of an IPVS_CMD_NEW_DEST message.
+// Kernels before 3.18 don't tell the address family of a destination, which
+// is the same as the one of its service then.
+func parseIPVSDestination(b []byte, af uint16) (*ipvsDestination, error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var dest ipvsDestination
+	for ad.Next() {
+		if ad.Type() != ipvsCmdAttrDest {
+			continue
+		}
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			var addr []byte
+			for nad.Next() {
+				switch nad.Type() {
+				case ipvsDestAttrAddr:
+					addr = nad.Bytes()
+				case ipvsDestAttrPort:
+					if port := nad.Bytes(); len(port) == 2 {
+						dest.RemotePort = binary.BigEndian.Uint16(port)
+					}
+				case ipvsDestAttrFwdMethod:
+					method := nad.Uint32() & ipvsConnFFwdMask
+					dest.ForwardMethod = fmt.Sprint(method)
+					if method < uint32(len(ipvsForwardMethods)) {
+						dest.ForwardMethod = ipvsForwardMethods[method]
+					}
+				case ipvsDestAttrUThresh:
+					dest.UpperThreshold = nad.Uint32()
+				case ipvsDestAttrLThresh:
+					dest.LowerThreshold = nad.Uint32()
+				case ipvsDestAttrAddrFamily:
+					af = nad.Uint16()
+				}
+			}
+			dest.RemoteAddress = ipvsAddress(af, addr)
+			return nil
+		})
+	}
+	return &dest, ad.Err()
+}
+
+// parseIPVSDaemon parses the attributes of an IPVS_CMD_NEW_DAEMON message.
+func parseIPVSDaemon(b []byte) (*ipvsDaemon, error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var daemon ipvsDaemon
+	for ad.Next() {
+		if ad.Type() != ipvsCmdAttrDaemon {
+			continue
+		}
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				switch nad.Type() {
+				case ipvsDaemonAttrState:
+					state := nad.Uint32()
+					daemon.State = ipvsDaemonStates[state]
+					if daemon.State == "" {
+						daemon.State = fmt.Sprint(state)
+					}
+				case ipvsDaemonAttrMcastIfn:
+					daemon.Interface = nad.String()
+				case ipvsDaemonAttrSyncID:
+					daemon.SyncID = nad.Uint32()
+				}
+			}
+			return nil
+		})
+	}
+	return &daemon, ad.Err()
+}
+
+// ipvsAddress formats an address of a union nf_inet_addr.
+func ipvsAddress(af uint16, addr []byte) string {
+	switch {
+	case af == unix.AF_INET && len(addr) >= net.IPv4len:
+		return net.IP(addr[:net.IPv4len]).String()
+	case af == unix.AF_INET6 && len(addr) >= net.IPv6len:
+		return net.IP(addr[:net.IPv6len]).String()
+	}
+	return ""
+}
+
+func ipvsFlagNames(flags uint32) []string {
+	var names []string
+	for i, name := range ipvsServiceFlags {
+		if flags&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var _ ipvsStater = &mockIPVSStater{}
+
+type mockIPVSStater struct {
+	fixtures string
+}
+
+func (s *mockIPVSStater) unmarshalJSONFile(filename string, v interface{}) error {
+	b, err := ioutil.ReadFile(filepath.Join(s.fixtures, filename))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *mockIPVSStater) Close() error { return nil }
+
+func (s *mockIPVSStater) Services() ([]ipvsService, error) {
+	var services []ipvsService
+	err := s.unmarshalJSONFile("services.json", &services)
+	return services, err
+}
+
+func (s *mockIPVSStater) Daemons() ([]ipvsDaemon, error) {
+	var daemons []ipvsDaemon
+	err := s.unmarshalJSONFile("daemons.json", &daemons)
+	return daemons, err
+}
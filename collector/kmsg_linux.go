@@ -0,0 +1,186 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nokmsg
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const kmsgSubsystem = "kmsg"
+
+var (
+	kmsgRules = kingpin.Flag("collector.kmsg.rule", "Additional rule to classify kernel log messages, in the form <name>=<regexp>. Can be repeated.").Strings()
+
+	// kmsgBuiltinRules classify well known kernel problems.
+	kmsgBuiltinRules = []string{
+		`oom_kill=(Out of memory|Memory cgroup out of memory): Killed process`,
+		`hung_task=blocked for more than \d+ seconds`,
+		`io_error=(Buffer )?I/O error|blk_update_request: .*error`,
+		`mce=Machine check events logged|\[Hardware Error\]`,
+		`soft_lockup=soft lockup - CPU#\d+ stuck`,
+		`hard_lockup=Watchdog detected hard LOCKUP`,
+		`segfault=segfault at [0-9a-f]+ ip`,
+		`link_down=Link is Down`,
+		`link_up=Link is Up`,
+	}
+
+	// The tailer counting the kernel messages logged since the exporter started.
+	kmsgTailerOnce sync.Once
+	kmsgTail       *kmsgTailer
+	kmsgTailErr    error
+)
+
+type kmsgCollector struct {
+	messages *prometheus.Desc
+	matches  *prometheus.Desc
+	lost     *prometheus.Desc
+	tailer   *kmsgTailer
+	logger   log.Logger
+}
+
+func init() {
+	registerCollector(kmsgSubsystem, defaultDisabled, NewKmsgCollector)
+}
+
+// NewKmsgCollector returns a new Collector exposing counters of kernel log
+// messages.
+func NewKmsgCollector(logger log.Logger) (Collector, error) {
+	kmsgTailerOnce.Do(func() {
+		rules, err := parseKmsgRules(append(kmsgBuiltinRules, *kmsgRules...))
+		if err != nil {
+			kmsgTailErr = err
+			return
+		}
+		kmsgTail = newKmsgTailer(rules)
+		kmsgTailErr = subscribeKmsg(kmsgTail.handle, logger)
+	})
+	if kmsgTailErr != nil {
+		return nil, kmsgTailErr
+	}
+
+	return &kmsgCollector{
+		messages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "messages_total"),
+			"Number of kernel log messages by facility and priority.",
+			[]string{"facility", "priority"}, nil,
+		),
+		matches: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "rule_matches_total"),
+			"Number of kernel log messages matching a classification rule.",
+			[]string{"rule", "facility", "priority"}, nil,
+		),
+		lost: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "lost_messages_total"),
+			"Number of kernel log messages overwritten in the ring buffer before they could be read.",
+			nil, nil,
+		),
+		tailer: kmsgTail,
+		logger: logger,
+	}, nil
+}
+
+func (c *kmsgCollector) Update(ch chan<- prometheus.Metric) error {
+	c.tailer.mtx.Lock()
+	defer c.tailer.mtx.Unlock()
+
+	for k, v := range c.tailer.messages {
+		ch <- prometheus.MustNewConstMetric(c.messages, prometheus.CounterValue, float64(v), k.facility, k.priority)
+	}
+	for k, v := range c.tailer.matches {
+		ch <- prometheus.MustNewConstMetric(c.matches, prometheus.CounterValue, float64(v), k.rule, k.facility, k.priority)
+	}
+	ch <- prometheus.MustNewConstMetric(c.lost, prometheus.CounterValue, float64(c.tailer.lost))
+
+	return nil
+}
+
+type kmsgRule struct {
+	name   string
+	regexp *regexp.Regexp
+}
+
+func parseKmsgRules(specs []string) ([]kmsgRule, error) {
+	rules := make([]kmsgRule, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid kmsg rule %q", spec)
+		}
+		re, err := regexp.Compile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid kmsg rule %q: %w", parts[0], err)
+		}
+		rules = append(rules, kmsgRule{name: parts[0], regexp: re})
+	}
+	return rules, nil
+}
+
+type kmsgMessageKey struct {
+	facility string
+	priority string
+}
+
+type kmsgMatchKey struct {
+	rule     string
+	facility string
+	priority string
+}
+
+// kmsgTailer keeps counters about kernel log records.
+type kmsgTailer struct {
+	rules []kmsgRule
+
+	mtx      sync.Mutex
+	messages map[kmsgMessageKey]uint64
+	matches  map[kmsgMatchKey]uint64
+	lost     uint64
+	seen     bool
+	lastSeq  uint64
+}
+
+func newKmsgTailer(rules []kmsgRule) *kmsgTailer {
+	return &kmsgTailer{
+		rules:    rules,
+		messages: map[kmsgMessageKey]uint64{},
+		matches:  map[kmsgMatchKey]uint64{},
+	}
+}
+
+func (t *kmsgTailer) handle(r kmsgRecord) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	// Records lost to an overrun show up as a gap in the sequence numbers.
+	if t.seen && r.sequence > t.lastSeq+1 {
+		t.lost += r.sequence - t.lastSeq - 1
+	}
+	t.seen = true
+	t.lastSeq = r.sequence
+
+	t.messages[kmsgMessageKey{r.facility, r.priority}]++
+	for _, rule := range t.rules {
+		if rule.regexp.MatchString(r.message) {
+			t.matches[kmsgMatchKey{rule.name, r.facility, r.priority}]++
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nokmsg
+
+package collector
+
+import (
+	"os"
+	"testing"
+)
+
+func TestKmsgTailer(t *testing.T) {
+	rules, err := parseKmsgRules(append(kmsgBuiltinRules, `systemd=^systemd\[`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("fixtures/kmsg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tailer := newKmsgTailer(rules)
+	if err := readKmsg(f, tailer.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[kmsgMessageKey]uint64{
+		{"kern", "info"}:   4,
+		{"kern", "err"}:    4,
+		{"kern", "emerg"}:  1,
+		{"daemon", "info"}: 1,
+	}
+	for k, want := range messages {
+		if got := tailer.messages[k]; got != want {
+			t.Errorf("want %d messages for %v, got %d", want, k, got)
+		}
+	}
+	if len(tailer.messages) != len(messages) {
+		t.Errorf("want %d message keys, got %d", len(messages), len(tailer.messages))
+	}
+
+	matches := map[kmsgMatchKey]uint64{
+		{"link_down", "kern", "info"}:    1,
+		{"link_up", "kern", "info"}:      1,
+		{"io_error", "kern", "err"}:      2,
+		{"oom_kill", "kern", "err"}:      2,
+		{"segfault", "kern", "info"}:     1,
+		{"soft_lockup", "kern", "emerg"}: 1,
+		{"systemd", "daemon", "info"}:    1,
+	}
+	for k, want := range matches {
+		if got := tailer.matches[k]; got != want {
+			t.Errorf("want %d matches for %v, got %d", want, k, got)
+		}
+	}
+	if len(tailer.matches) != len(matches) {
+		t.Errorf("want %d match keys, got %d", len(matches), len(tailer.matches))
+	}
+
+	if want, got := uint64(11), tailer.lost; want != got {
+		t.Errorf("want %d lost messages, got %d", want, got)
+	}
+}
+
+func TestParseKmsgRules(t *testing.T) {
+	for _, spec := range []string{"noregexp", "=foo", "bad=("} {
+		if _, err := parseKmsgRules([]string{spec}); err == nil {
+			t.Errorf("%s: expected error, got none", spec)
+		}
+	}
+}
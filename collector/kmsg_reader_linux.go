@@ -0,0 +1,166 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	kmsgPath = kingpin.Flag("collector.kmsg.path", "Path of the kernel log device. A regular file is read from the start, e.g. for testing.").Default("/dev/kmsg").String()
+
+	kmsgFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	kmsgPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+	// The kernel log is read at most once per process and its records are
+	// passed on to all subscribers.
+	kmsgStreamOnce sync.Once
+	kmsgStreamErr  error
+	kmsgStreamMtx  sync.RWMutex
+	kmsgStreamSubs []func(kmsgRecord)
+)
+
+// subscribeKmsg registers fn to be called for every new kernel log record.
+// The kernel log is opened by the first subscriber.
+func subscribeKmsg(fn func(kmsgRecord), logger log.Logger) error {
+	kmsgStreamMtx.Lock()
+	kmsgStreamSubs = append(kmsgStreamSubs, fn)
+	kmsgStreamMtx.Unlock()
+
+	kmsgStreamOnce.Do(func() {
+		f, err := openKmsg(*kmsgPath)
+		if err != nil {
+			kmsgStreamErr = err
+			return
+		}
+		go func() {
+			defer f.Close()
+			err := readKmsg(f, func(r kmsgRecord) {
+				kmsgStreamMtx.RLock()
+				defer kmsgStreamMtx.RUnlock()
+				for _, sub := range kmsgStreamSubs {
+					sub(r)
+				}
+			})
+			if err != nil {
+				level.Error(logger).Log("msg", "stopped reading kernel log", "path", *kmsgPath, "err", err)
+			}
+		}()
+	})
+	return kmsgStreamErr
+}
+
+// openKmsg opens the kernel log. When it is the kmsg device, it skips the
+// messages already in the ring buffer so only new messages are counted.
+func openKmsg(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Mode()&os.ModeCharDevice != 0 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to seek to end of %s: %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+// kmsgRecord is a single kernel log record, see
+// Documentation/ABI/testing/dev-kmsg.
+type kmsgRecord struct {
+	facility string
+	priority string
+	sequence uint64
+	message  string
+}
+
+// parseKmsgRecord parses a record in the form
+// <prefix>,<sequence>,<timestamp>,<flags>[,...];<message>.
+func parseKmsgRecord(line string) (kmsgRecord, error) {
+	parts := strings.SplitN(line, ";", 2)
+	if len(parts) != 2 {
+		return kmsgRecord{}, fmt.Errorf("invalid kmsg record %q", line)
+	}
+	fields := strings.Split(parts[0], ",")
+	if len(fields) < 4 {
+		return kmsgRecord{}, fmt.Errorf("invalid kmsg record header %q", parts[0])
+	}
+	prefix, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return kmsgRecord{}, fmt.Errorf("invalid kmsg record prefix %q: %w", fields[0], err)
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return kmsgRecord{}, fmt.Errorf("invalid kmsg record sequence %q: %w", fields[1], err)
+	}
+
+	r := kmsgRecord{
+		facility: strconv.FormatUint(prefix>>3, 10),
+		priority: kmsgPriorities[prefix&7],
+		sequence: seq,
+		message:  parts[1],
+	}
+	if int(prefix>>3) < len(kmsgFacilities) {
+		r.facility = kmsgFacilities[prefix>>3]
+	}
+	return r, nil
+}
+
+// readKmsg reads records and passes them to fn until r returns an error. A
+// ring buffer overrun is reported by the kernel as EPIPE, after which
+// reading resumes at the oldest available record.
+func readKmsg(r io.Reader, fn func(kmsgRecord)) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\n")
+		// Continuation lines carry the structured key/value dictionary of
+		// the previous record.
+		if line != "" && line[0] != ' ' {
+			if record, err := parseKmsgRecord(line); err == nil {
+				fn(record)
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, syscall.EPIPE):
+		case err == io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
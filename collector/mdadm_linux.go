@@ -18,7 +18,11 @@ package collector
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -92,6 +96,47 @@ var (
 		[]string{"device"},
 		nil,
 	)
+
+	syncSpeedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "md", "sync_speed_bytes_per_second"),
+		"Current speed of the resync, recovery, check or reshape of device.",
+		[]string{"device"},
+		nil,
+	)
+
+	syncActionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "md", "sync_action"),
+		"Indicates the current sync action of md-device.",
+		[]string{"device", "action"},
+		nil,
+	)
+
+	mismatchDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "md", "mismatch_sectors"),
+		"Number of sectors found to be inconsistent by the last check or repair of device.",
+		[]string{"device"},
+		nil,
+	)
+
+	degradedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "md", "degraded_disks"),
+		"Number of disks missing from device.",
+		[]string{"device"},
+		nil,
+	)
+
+	memberStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "md", "member_state"),
+		"Indicates whether a state flag is set on a member disk of md-device.",
+		[]string{"device", "member", "state"},
+		nil,
+	)
+
+	// mdSyncActions are the values of md/sync_action, see md(4).
+	mdSyncActions = []string{"idle", "resync", "recover", "check", "repair", "reshape", "frozen"}
+
+	// mdMemberStates are the flags of md/dev-*/state, see md(4).
+	mdMemberStates = []string{"faulty", "in_sync", "write_mostly", "blocked", "spare", "write_error", "want_replacement", "replacement"}
 )
 
 func (c *mdadmCollector) Update(ch chan<- prometheus.Metric) error {
@@ -186,7 +231,121 @@ func (c *mdadmCollector) Update(ch chan<- prometheus.Metric) error {
 			float64(mdStat.BlocksSynced),
 			mdStat.Name,
 		)
+
+		if err := c.updateSysfsStats(ch, mdStat.Name); err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read md sysfs attributes", "device", mdStat.Name, "err", err)
+		}
 	}
 
 	return nil
 }
+
+// updateSysfsStats exports the sync status of an md-device and the state of
+// its members from sysfs.
+func (c *mdadmCollector) updateSysfsStats(ch chan<- prometheus.Metric, device string) error {
+	stats, err := readMdSysfsStats(sysFilePath(filepath.Join("block", device, "md")), c.logger)
+	if err != nil {
+		return err
+	}
+
+	// Arrays without redundancy, e.g. raid0 and linear, have no sync action.
+	if stats.syncAction != "" {
+		for _, a := range mdSyncActions {
+			v := 0.0
+			if a == stats.syncAction {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(syncActionDesc, prometheus.GaugeValue, v, device, a)
+		}
+	}
+	for desc, attr := range map[*prometheus.Desc]string{
+		syncSpeedDesc: "sync_speed",
+		mismatchDesc:  "mismatch_cnt",
+		degradedDesc:  "degraded",
+	} {
+		if v, ok := stats.attributes[attr]; ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, device)
+		}
+	}
+	for member, flags := range stats.memberStates {
+		for _, s := range mdMemberStates {
+			v := 0.0
+			if flags[s] {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(memberStateDesc, prometheus.GaugeValue, v, device, member, s)
+		}
+	}
+	return nil
+}
+
+// mdSysfsStats are the sysfs attributes of an md-device. Attributes missing
+// for the raid level of the device are left out.
+type mdSysfsStats struct {
+	syncAction string
+	// attributes are the numeric attributes by name, with sync_speed in
+	// bytes per second.
+	attributes map[string]float64
+	// memberStates are the state flags of the members by name.
+	memberStates map[string]map[string]bool
+}
+
+// readMdSysfsStats reads the attributes of the md directory of a device. Each
+// attribute is read independently, as which ones exist depends on the raid
+// level.
+func readMdSysfsStats(mdDir string, logger log.Logger) (*mdSysfsStats, error) {
+	stats := &mdSysfsStats{
+		attributes:   map[string]float64{},
+		memberStates: map[string]map[string]bool{},
+	}
+
+	if action, err := readMdAttribute(mdDir, "sync_action"); err == nil {
+		stats.syncAction = action
+	} else if !os.IsNotExist(err) {
+		level.Debug(logger).Log("msg", "failed to read md attribute", "dir", mdDir, "attribute", "sync_action", "err", err)
+	}
+
+	// sync_speed is "none" while no sync action is running.
+	if speed, err := readMdAttribute(mdDir, "sync_speed"); err == nil {
+		if kib, err := strconv.ParseUint(speed, 10, 64); err == nil {
+			stats.attributes["sync_speed"] = float64(kib * 1024)
+		} else if speed == "none" {
+			stats.attributes["sync_speed"] = 0
+		} else {
+			level.Debug(logger).Log("msg", "invalid md sync_speed", "dir", mdDir, "sync_speed", speed, "err", err)
+		}
+	}
+
+	for _, attr := range []string{"mismatch_cnt", "degraded"} {
+		if v, err := readUintFromFile(filepath.Join(mdDir, attr)); err == nil {
+			stats.attributes[attr] = float64(v)
+		}
+	}
+
+	members, err := filepath.Glob(filepath.Join(mdDir, "dev-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, memberDir := range members {
+		member := strings.TrimPrefix(filepath.Base(memberDir), "dev-")
+		state, err := readMdAttribute(memberDir, "state")
+		if err != nil {
+			level.Debug(logger).Log("msg", "failed to read md member state", "dir", mdDir, "member", member, "err", err)
+			continue
+		}
+		flags := map[string]bool{}
+		for _, f := range strings.Split(state, ",") {
+			flags[f] = true
+		}
+		stats.memberStates[member] = flags
+	}
+	return stats, nil
+}
+
+func readMdAttribute(dir, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nomdadm
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReadMdSysfsStats(t *testing.T) {
+	tests := []struct {
+		device string
+		want   mdSysfsStats
+	}{
+		{
+			// raid0 has no sync action, mismatch count or degraded disks.
+			device: "md10",
+			want: mdSysfsStats{
+				attributes: map[string]float64{},
+				memberStates: map[string]map[string]bool{
+					"sda1": {"in_sync": true},
+					"sdb1": {"in_sync": true},
+				},
+			},
+		},
+		{
+			device: "md0",
+			want: mdSysfsStats{
+				syncAction: "idle",
+				attributes: map[string]float64{"sync_speed": 0, "mismatch_cnt": 0, "degraded": 0},
+				memberStates: map[string]map[string]bool{
+					"sdi1": {"in_sync": true},
+					"sdj1": {"in_sync": true, "write_mostly": true},
+				},
+			},
+		},
+		{
+			// A degraded raid1 recovering onto a spare.
+			device: "md6",
+			want: mdSysfsStats{
+				syncAction: "recover",
+				attributes: map[string]float64{"sync_speed": 259783 * 1024, "mismatch_cnt": 0, "degraded": 1},
+				memberStates: map[string]map[string]bool{
+					"sda2": {"in_sync": true},
+					"sdb2": {"faulty": true},
+					"sdc":  {"spare": true},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := readMdSysfsStats("fixtures/sys/block/"+test.device+"/md", log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: want %+v, got %+v", test.device, test.want, *got)
+		}
+	}
+}
@@ -17,18 +17,59 @@ package collector
 
 import (
 	"fmt"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
 	// 64-bit float mantissa: https://en.wikipedia.org/wiki/Double-precision_floating-point_format
 	float64Mantissa uint64 = 9007199254740992
+
+	mountStatsExportsInclude = kingpin.Flag(
+		"collector.mountstats.exports-include",
+		"Regexp of NFS exports to include for mountstats collector.",
+	).Default(".*").String()
+	mountStatsExportsExclude = kingpin.Flag(
+		"collector.mountstats.exports-exclude",
+		"Regexp of NFS exports to exclude for mountstats collector.",
+	).Default("^$").String()
+	mountStatsMountPointsInclude = kingpin.Flag(
+		"collector.mountstats.mount-points-include",
+		"Regexp of NFS mount points to include for mountstats collector.",
+	).Default(".*").String()
+	mountStatsMountPointsExclude = kingpin.Flag(
+		"collector.mountstats.mount-points-exclude",
+		"Regexp of NFS mount points to exclude for mountstats collector.",
+	).Default("^$").String()
+	mountStatsOperations = kingpin.Flag(
+		"collector.mountstats.operations",
+		"Regexp of NFS operations to expose per operation statistics for.",
+	).Default("^(READ|WRITE|GETATTR|LOOKUP|ACCESS)$").String()
+	mountStatsLatencyHistograms = kingpin.Flag(
+		"collector.mountstats.latency-histograms",
+		"Enables metric mountstats_nfs_operations_latency_seconds.",
+	).Bool()
+	mountStatsLatencyBuckets = kingpin.Flag(
+		"collector.mountstats.latency-buckets",
+		"Comma separated upper bounds in seconds of the NFS operation latency histogram buckets.",
+	).Default("0.0005,0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5").String()
+
+	// The latency histograms, built from the difference between scrapes.
+	nfsLatencyOnce sync.Once
+	nfsLatency     *nfsLatencyTracker
+	nfsLatencyErr  error
 )
 
+// NFS operations not seen for this long are removed from the latency tracker.
+const nfsLatencyStaleTimeout = 10 * time.Minute
+
 type mountStatsCollector struct {
 	// General statistics
 	NFSAgeSecondsTotal *prometheus.Desc
@@ -52,6 +93,7 @@ type mountStatsCollector struct {
 	NFSOperationsQueueTimeSecondsTotal    *prometheus.Desc
 	NFSOperationsResponseTimeSecondsTotal *prometheus.Desc
 	NFSOperationsRequestTimeSecondsTotal  *prometheus.Desc
+	NFSOperationsLatencySeconds           *prometheus.Desc
 
 	// Transport statistics
 	NFSTransportBindTotal              *prometheus.Desc
@@ -95,6 +137,13 @@ type mountStatsCollector struct {
 
 	proc procfs.Proc
 
+	exportsInclude     *regexp.Regexp
+	exportsExclude     *regexp.Regexp
+	mountPointsInclude *regexp.Regexp
+	mountPointsExclude *regexp.Regexp
+	operations         *regexp.Regexp
+	latency            *nfsLatencyTracker
+
 	logger log.Logger
 }
 
@@ -121,6 +170,35 @@ func NewMountStatsCollector(logger log.Logger) (Collector, error) {
 		return nil, fmt.Errorf("failed to open /proc/self: %w", err)
 	}
 
+	var patterns [5]*regexp.Regexp
+	for i, flag := range []struct{ name, value string }{
+		{"exports-include", *mountStatsExportsInclude},
+		{"exports-exclude", *mountStatsExportsExclude},
+		{"mount-points-include", *mountStatsMountPointsInclude},
+		{"mount-points-exclude", *mountStatsMountPointsExclude},
+		{"operations", *mountStatsOperations},
+	} {
+		if patterns[i], err = regexp.Compile(flag.value); err != nil {
+			return nil, fmt.Errorf("invalid collector.mountstats.%s: %w", flag.name, err)
+		}
+	}
+
+	var latency *nfsLatencyTracker
+	if *mountStatsLatencyHistograms {
+		nfsLatencyOnce.Do(func() {
+			buckets, err := parseBuckets(*mountStatsLatencyBuckets)
+			if err != nil {
+				nfsLatencyErr = fmt.Errorf("invalid latency buckets: %w", err)
+				return
+			}
+			nfsLatency = newNFSLatencyTracker(buckets)
+		})
+		if nfsLatencyErr != nil {
+			return nil, nfsLatencyErr
+		}
+		latency = nfsLatency
+	}
+
 	const (
 		// For the time being, only NFS statistics are available via this mechanism.
 		subsystem = "mountstats_nfs"
@@ -503,7 +581,22 @@ func NewMountStatsCollector(logger log.Logger) (Collector, error) {
 			nil,
 		),
 
-		proc:   proc,
+		NFSOperationsLatencySeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "operations_latency_seconds"),
+			"Histogram of the average request time of NFS operations between scrapes, weighted by the number of requests.",
+			opLabels,
+			nil,
+		),
+
+		proc: proc,
+
+		exportsInclude:     patterns[0],
+		exportsExclude:     patterns[1],
+		mountPointsInclude: patterns[2],
+		mountPointsExclude: patterns[3],
+		operations:         patterns[4],
+		latency:            latency,
+
 		logger: logger,
 	}, nil
 }
@@ -537,6 +630,11 @@ func (c *mountStatsCollector) Update(ch chan<- prometheus.Metric) error {
 			mountAddress = miStats.SuperOptions["addr"]
 		}
 
+		if c.excluded(m.Device, m.Mount) {
+			level.Debug(c.logger).Log("msg", "Ignoring NFS mount", "export", m.Device, "mountpoint", m.Mount)
+			continue
+		}
+
 		deviceIdentifier := nfsDeviceIdentifier{m.Device, stats.Transport.Protocol, mountAddress}
 		i := deviceList[deviceIdentifier]
 		if i {
@@ -548,9 +646,19 @@ func (c *mountStatsCollector) Update(ch chan<- prometheus.Metric) error {
 		c.updateNFSStats(ch, stats, m.Device, stats.Transport.Protocol, mountAddress)
 	}
 
+	if c.latency != nil {
+		c.latency.prune(time.Now())
+	}
 	return nil
 }
 
+// excluded returns whether an NFS mount is filtered out by the export and
+// mount point patterns.
+func (c *mountStatsCollector) excluded(export, mountPoint string) bool {
+	return !c.exportsInclude.MatchString(export) || c.exportsExclude.MatchString(export) ||
+		!c.mountPointsInclude.MatchString(mountPoint) || c.mountPointsExclude.MatchString(mountPoint)
+}
+
 func (c *mountStatsCollector) updateNFSStats(ch chan<- prometheus.Metric, s *procfs.MountStatsNFS, export, protocol, mountAddress string) {
 	labelValues := []string{export, protocol, mountAddress}
 	ch <- prometheus.MustNewConstMetric(
@@ -687,6 +795,9 @@ func (c *mountStatsCollector) updateNFSStats(ch chan<- prometheus.Metric, s *pro
 	)
 
 	for _, op := range s.Operations {
+		if !c.operations.MatchString(op.Operation) {
+			continue
+		}
 		opLabelValues := []string{export, protocol, mountAddress, op.Operation}
 
 		ch <- prometheus.MustNewConstMetric(
@@ -744,6 +855,18 @@ func (c *mountStatsCollector) updateNFSStats(ch chan<- prometheus.Metric, s *pro
 			float64(op.CumulativeTotalRequestMilliseconds%float64Mantissa)/1000.0,
 			opLabelValues...,
 		)
+
+		if c.latency != nil {
+			key := nfsOperationKey{nfsDeviceIdentifier{export, protocol, mountAddress}, op.Operation}
+			count, sum, buckets := c.latency.observe(key, op.Requests, op.CumulativeTotalRequestMilliseconds, time.Now())
+			ch <- prometheus.MustNewConstHistogram(
+				c.NFSOperationsLatencySeconds,
+				count,
+				sum,
+				buckets,
+				opLabelValues...,
+			)
+		}
 	}
 
 	ch <- prometheus.MustNewConstMetric(
@@ -928,3 +1051,64 @@ func (c *mountStatsCollector) updateNFSStats(ch chan<- prometheus.Metric, s *pro
 		labelValues...,
 	)
 }
+
+type nfsOperationKey struct {
+	device    nfsDeviceIdentifier
+	operation string
+}
+
+type nfsOperationLatency struct {
+	requests     uint64
+	milliseconds uint64
+	lastSeen     time.Time
+	histogram    *histogram
+}
+
+// nfsLatencyTracker keeps histograms of the average latency of NFS operations
+// between scrapes, as the kernel only exposes cumulative request times.
+type nfsLatencyTracker struct {
+	buckets []float64
+
+	mtx sync.Mutex
+	ops map[nfsOperationKey]*nfsOperationLatency
+}
+
+func newNFSLatencyTracker(buckets []float64) *nfsLatencyTracker {
+	return &nfsLatencyTracker{
+		buckets: buckets,
+		ops:     map[nfsOperationKey]*nfsOperationLatency{},
+	}
+}
+
+// observe accounts for the requests of an operation since its previous
+// observation and returns the count, sum and buckets of its histogram.
+func (t *nfsLatencyTracker) observe(key nfsOperationKey, requests, milliseconds uint64, now time.Time) (uint64, float64, map[float64]uint64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	l, ok := t.ops[key]
+	if !ok {
+		// The first observation only records the baseline.
+		l = &nfsOperationLatency{histogram: newHistogram(t.buckets)}
+		t.ops[key] = l
+	} else if requests > l.requests && milliseconds >= l.milliseconds {
+		// Counters going backwards, e.g. after a remount, reset the baseline.
+		n := requests - l.requests
+		l.histogram.observeN(float64(milliseconds-l.milliseconds)/1000/float64(n), n)
+	}
+	l.requests, l.milliseconds, l.lastSeen = requests, milliseconds, now
+	return l.histogram.count, l.histogram.sum, l.histogram.cumulative()
+}
+
+// prune removes the operations of mounts which have not been seen for the
+// stale timeout.
+func (t *nfsLatencyTracker) prune(now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for k, l := range t.ops {
+		if now.Sub(l.lastSeen) > nfsLatencyStaleTimeout {
+			delete(t.ops, k)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nomountstats
+
+package collector
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMountStatsExcluded(t *testing.T) {
+	c := &mountStatsCollector{
+		exportsInclude:     regexp.MustCompile("^192.168.1.1:"),
+		exportsExclude:     regexp.MustCompile(":/srv/scratch$"),
+		mountPointsInclude: regexp.MustCompile(".*"),
+		mountPointsExclude: regexp.MustCompile("^/mnt/nfs/test-dupe$"),
+	}
+	for _, test := range []struct {
+		export, mountPoint string
+		want               bool
+	}{
+		{"192.168.1.1:/srv/test", "/mnt/nfs/test", false},
+		{"192.168.1.1:/srv/test", "/mnt/nfs/test-dupe", true},
+		{"192.168.1.1:/srv/scratch", "/mnt/nfs/scratch", true},
+		{"192.168.1.2:/srv/test", "/mnt/nfs/other", true},
+	} {
+		if got := c.excluded(test.export, test.mountPoint); got != test.want {
+			t.Errorf("%s on %s: want excluded %v, got %v", test.export, test.mountPoint, test.want, got)
+		}
+	}
+}
+
+func TestNFSLatencyTracker(t *testing.T) {
+	tracker := newNFSLatencyTracker([]float64{0.001, 0.01, 0.1})
+	key := nfsOperationKey{nfsDeviceIdentifier{"192.168.1.1:/srv/test", "tcp", "192.168.1.1"}, "READ"}
+	now := time.Unix(0, 0)
+
+	for _, test := range []struct {
+		requests, milliseconds uint64
+		count                  uint64
+		sum                    float64
+		buckets                map[float64]uint64
+	}{
+		// The baseline is not observed.
+		{100, 1000, 0, 0, map[float64]uint64{0.001: 0, 0.01: 0, 0.1: 0}},
+		// 10 requests taking 5ms on average.
+		{110, 1050, 10, 0.05, map[float64]uint64{0.001: 0, 0.01: 10, 0.1: 10}},
+		// No requests since the previous scrape.
+		{110, 1050, 10, 0.05, map[float64]uint64{0.001: 0, 0.01: 10, 0.1: 10}},
+		// The counters were reset.
+		{5, 10, 10, 0.05, map[float64]uint64{0.001: 0, 0.01: 10, 0.1: 10}},
+		// 2 requests taking 200ms on average.
+		{7, 410, 12, 0.45, map[float64]uint64{0.001: 0, 0.01: 10, 0.1: 10}},
+	} {
+		count, sum, buckets := tracker.observe(key, test.requests, test.milliseconds, now)
+		if count != test.count || sum != test.sum || !reflect.DeepEqual(buckets, test.buckets) {
+			t.Errorf("requests %d: want %d/%v/%v, got %d/%v/%v", test.requests,
+				test.count, test.sum, test.buckets, count, sum, buckets)
+		}
+	}
+
+	tracker.prune(now.Add(nfsLatencyStaleTimeout))
+	if len(tracker.ops) != 1 {
+		t.Fatalf("want 1 tracked operation, got %d", len(tracker.ops))
+	}
+	tracker.prune(now.Add(nfsLatencyStaleTimeout + time.Second))
+	if len(tracker.ops) != 0 {
+		t.Errorf("want no tracked operations, got %d", len(tracker.ops))
+	}
+}
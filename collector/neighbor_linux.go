@@ -0,0 +1,174 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noneighbor
+
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var neighborFixtures = kingpin.Flag("collector.neighbor.fixtures", "test fixtures to use for neighbor collector metrics").Default("").Hidden().String()
+
+type neighborCollector struct {
+	entries  *prometheus.Desc
+	gcThresh []*prometheus.Desc
+	logger   log.Logger
+}
+
+func init() {
+	registerCollector("neighbor", defaultDisabled, NewNeighborCollector)
+}
+
+// NewNeighborCollector returns a new Collector exposing ARP and NDP neighbour
+// table stats.
+func NewNeighborCollector(logger log.Logger) (Collector, error) {
+	c := &neighborCollector{
+		entries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "neighbor", "entries"),
+			"Number of neighbour table entries by device, address family and state.",
+			[]string{"device", "family", "state"}, nil,
+		),
+		logger: logger,
+	}
+	for i := 1; i <= 3; i++ {
+		c.gcThresh = append(c.gcThresh, prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "neighbor", fmt.Sprintf("gc_thresh%d", i)),
+			fmt.Sprintf("Value of the gc_thresh%d sysctl of the neighbour table.", i),
+			[]string{"family"}, nil,
+		))
+	}
+	return c, nil
+}
+
+// neighborEntry is an entry of the neighbour table.
+type neighborEntry struct {
+	Device string
+	Family string
+	State  string
+}
+
+func (c *neighborCollector) Update(ch chan<- prometheus.Metric) error {
+	entries, err := c.neighbors()
+	if err != nil {
+		return fmt.Errorf("could not get neighbour table entries: %w", err)
+	}
+
+	counts := map[neighborEntry]uint64{}
+	for _, e := range entries {
+		counts[e]++
+	}
+	for k, v := range counts {
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(v), k.Device, k.Family, k.State)
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		for i, desc := range c.gcThresh {
+			value, err := readUintFromFile(procFilePath(fmt.Sprintf("sys/net/%s/neigh/default/gc_thresh%d", family, i+1)))
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "failed to read neighbour table threshold", "family", family, "err", err)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), family)
+		}
+	}
+	return nil
+}
+
+// neighbors returns the entries of the neighbour tables. It falls back to
+// /proc/net/arp, which only has IPv4 entries, if the tables can't be dumped
+// via netlink.
+func (c *neighborCollector) neighbors() ([]neighborEntry, error) {
+	if *neighborFixtures != "" {
+		return readNeighborFixtures(*neighborFixtures)
+	}
+
+	entries, err := dumpNeighbors()
+	if err == nil {
+		return entries, nil
+	}
+	level.Debug(c.logger).Log("msg", "failed to dump neighbour tables via netlink, falling back to /proc/net/arp", "err", err)
+
+	file, err := os.Open(procFilePath("net/arp"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseProcNeighbors(file)
+}
+
+// ARP flags of /proc/net/arp, see include/uapi/linux/if_arp.h.
+const (
+	atfComplete  = 0x02
+	atfPermanent = 0x04
+)
+
+// parseProcNeighbors parses /proc/net/arp. Its flags only tell whether an
+// entry is permanent or valid, i.e. has a link layer address, so valid entries
+// are reported as reachable and others as incomplete, like their most common
+// states in the neighbour tables.
+func parseProcNeighbors(r io.Reader) ([]neighborEntry, error) {
+	scanner := bufio.NewScanner(r)
+	// Skip the header line.
+	scanner.Scan()
+
+	var entries []neighborEntry
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid ARP table line %q", scanner.Text())
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARP flags %q: %w", fields[2], err)
+		}
+
+		state := "incomplete"
+		switch {
+		case flags&atfPermanent != 0:
+			state = "permanent"
+		case flags&atfComplete != 0:
+			state = "reachable"
+		}
+		entries = append(entries, neighborEntry{Device: fields[5], Family: "ipv4", State: state})
+	}
+	return entries, scanner.Err()
+}
+
+func readNeighborFixtures(fixtures string) ([]neighborEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(fixtures, "neighbors.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []neighborEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noneighbor
+
+package collector
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseProcNeighbors(t *testing.T) {
+	file, err := os.Open("fixtures/proc/net/arp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries, err := parseProcNeighbors(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("want 6 entries, got %d", len(entries))
+	}
+	if want := (neighborEntry{Device: "eth1", Family: "ipv4", State: "reachable"}); entries[5] != want {
+		t.Errorf("want %+v, got %+v", want, entries[5])
+	}
+
+	entries, err = parseProcNeighbors(strings.NewReader(`IP address       HW type     Flags       HW address            Mask     Device
+10.0.0.1         0x1         0x0         00:00:00:00:00:00     *        eth0
+10.0.0.2         0x1         0x6         aa:bb:cc:dd:ee:ff     *        eth0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []neighborEntry{
+		{Device: "eth0", Family: "ipv4", State: "incomplete"},
+		{Device: "eth0", Family: "ipv4", State: "permanent"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("want %+v, got %+v", want, entries)
+	}
+}
+
+// neighborMessage encodes a struct ndmsg with an NDA_DST attribute.
+func neighborMessage(family uint8, index int32, state uint16) netlink.Message {
+	data := make([]byte, unix.SizeofNdMsg, unix.SizeofNdMsg+8)
+	data[0] = family
+	copy(data[4:8], nlenc.Int32Bytes(index))
+	copy(data[8:10], nlenc.Uint16Bytes(state))
+	data = append(data, 8, 0, unix.NDA_DST, 0, 10, 0, 0, 1)
+	return netlink.Message{Header: netlink.Header{Type: unix.RTM_NEWNEIGH}, Data: data}
+}
+
+func TestParseNeighborMessages(t *testing.T) {
+	msgs := []netlink.Message{
+		neighborMessage(unix.AF_INET, 2, unix.NUD_REACHABLE),
+		neighborMessage(unix.AF_INET6, 2, unix.NUD_STALE),
+		neighborMessage(unix.AF_INET6, 1, unix.NUD_NOARP),
+		neighborMessage(unix.AF_INET, 7, unix.NUD_FAILED),
+		neighborMessage(unix.AF_INET, 2, unix.NUD_STALE|unix.NUD_DELAY),
+		// Bridge forwarding database entries are skipped.
+		neighborMessage(unix.AF_BRIDGE, 2, unix.NUD_PERMANENT),
+	}
+	entries, err := parseNeighborMessages(msgs, map[int]string{1: "lo", 2: "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []neighborEntry{
+		{Device: "eth0", Family: "ipv4", State: "reachable"},
+		{Device: "eth0", Family: "ipv6", State: "stale"},
+		{Device: "lo", Family: "ipv6", State: "noarp"},
+		{Device: "7", Family: "ipv4", State: "failed"},
+		{Device: "eth0", Family: "ipv4", State: "0xc"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("want %+v, got %+v", want, entries)
+	}
+
+	if _, err := parseNeighborMessages([]netlink.Message{{Data: []byte{unix.AF_INET}}}, nil); err == nil {
+		t.Error("expected error for short message, got none")
+	}
+}
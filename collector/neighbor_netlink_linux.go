@@ -0,0 +1,102 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noneighbor
+
+package collector
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+var neighborFamilyNames = map[uint8]string{
+	unix.AF_INET:  "ipv4",
+	unix.AF_INET6: "ipv6",
+}
+
+var neighborStates = map[uint16]string{
+	unix.NUD_NONE:       "none",
+	unix.NUD_INCOMPLETE: "incomplete",
+	unix.NUD_REACHABLE:  "reachable",
+	unix.NUD_STALE:      "stale",
+	unix.NUD_DELAY:      "delay",
+	unix.NUD_PROBE:      "probe",
+	unix.NUD_FAILED:     "failed",
+	unix.NUD_NOARP:      "noarp",
+	unix.NUD_PERMANENT:  "permanent",
+}
+
+// dumpNeighbors returns the entries of the IPv4 and IPv6 neighbour tables.
+func dumpNeighbors() ([]neighborEntry, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETNEIGH,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: make([]byte, unix.SizeofNdMsg),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[int]string, len(ifis))
+	for _, ifi := range ifis {
+		devices[ifi.Index] = ifi.Name
+	}
+	return parseNeighborMessages(msgs, devices)
+}
+
+// parseNeighborMessages parses RTM_NEWNEIGH messages, which start with a
+// struct ndmsg. Entries of other families than IPv4 and IPv6, e.g. of bridge
+// forwarding databases, are skipped.
+func parseNeighborMessages(msgs []netlink.Message, devices map[int]string) ([]neighborEntry, error) {
+	var entries []neighborEntry
+	for _, m := range msgs {
+		if len(m.Data) < unix.SizeofNdMsg {
+			return nil, fmt.Errorf("short neighbour message of %d bytes", len(m.Data))
+		}
+		family, ok := neighborFamilyNames[m.Data[0]]
+		if !ok {
+			continue
+		}
+		index := int(nlenc.Int32(m.Data[4:8]))
+		state := nlenc.Uint16(m.Data[8:10])
+
+		device, ok := devices[index]
+		if !ok {
+			device = strconv.Itoa(index)
+		}
+		stateName, ok := neighborStates[state]
+		if !ok {
+			stateName = fmt.Sprintf("0x%x", state)
+		}
+		entries = append(entries, neighborEntry{Device: device, Family: family, State: stateName})
+	}
+	return entries, nil
+}
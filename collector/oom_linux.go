@@ -0,0 +1,386 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nooom
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const oomSubsystem = "oom"
+
+var (
+	oomCgroupInclude   = kingpin.Flag("collector.oom.cgroup-include", "Regexp of cgroups to export memory.events for, top-level slices by default.").Default(`^/[^/]+\.slice$`).String()
+	oomCgroupDepth     = kingpin.Flag("collector.oom.cgroup-depth", "Maximum depth of the cgroups to read memory.events of, with top-level cgroups at depth 1, 0 for no limit.").Default("1").Int()
+	oomVictimCacheSize = kingpin.Flag("collector.oom.victim-cache-size", "Maximum number of cgroup and command combinations to keep OOM kill counts for.").Default("100").Int()
+
+	// memory.events fields exported per cgroup.
+	oomCgroupEvents = map[string]bool{"high": true, "max": true, "oom": true, "oom_kill": true}
+	// Zones reclaim counters are split by on older kernels.
+	oomReclaimZones = map[string]bool{"": true, "dma": true, "dma32": true, "normal": true, "highmem": true, "movable": true, "device": true}
+
+	// OOM kills by cgroup and command seen in the kernel log since the
+	// exporter started.
+	oomVictimsOnce sync.Once
+	oomVictimCache *oomVictims
+
+	oomKillPattern   = regexp.MustCompile(`^oom-kill:.*,task_memcg=([^,]*),task=([^,]*),pid=(\d+)`)
+	oomKilledPattern = regexp.MustCompile(`Killed process (\d+) \((.*?)\)`)
+)
+
+type oomCollector struct {
+	kills          *prometheus.Desc
+	cgroupEvents   *prometheus.Desc
+	victimKills    *prometheus.Desc
+	reclaimScanned *prometheus.Desc
+	reclaimStolen  *prometheus.Desc
+	allocStalls    *prometheus.Desc
+	cgroupPattern  *regexp.Regexp
+	cgroupDepth    int
+	victims        *oomVictims
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector(oomSubsystem, defaultDisabled, NewOOMCollector)
+}
+
+// NewOOMCollector returns a new Collector exposing OOM kills and memory
+// reclaim activity.
+func NewOOMCollector(logger log.Logger) (Collector, error) {
+	pattern, err := regexp.Compile(*oomCgroupInclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cgroup include pattern: %w", err)
+	}
+
+	oomVictimsOnce.Do(func() {
+		oomVictimCache = newOOMVictims(*oomVictimCacheSize)
+		if err := subscribeKmsg(oomVictimCache.handle, logger); err != nil {
+			level.Info(logger).Log("msg", "kernel log is unavailable, OOM victims will not be reported", "err", err)
+		}
+	})
+
+	return &oomCollector{
+		kills: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "kills_total"),
+			"Number of processes killed by the OOM killer.",
+			nil, nil,
+		),
+		cgroupEvents: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "cgroup_memory_events_total"),
+			"Number of memory events of a cgroup from memory.events.",
+			[]string{"cgroup", "event"}, nil,
+		),
+		victimKills: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "victim_kills_total"),
+			"Number of processes killed by the OOM killer since the exporter started, by cgroup and command name.",
+			[]string{"cgroup", "comm"}, nil,
+		),
+		reclaimScanned: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "reclaim_scanned_pages_total"),
+			"Number of pages scanned for reclaim.",
+			[]string{"mode"}, nil,
+		),
+		reclaimStolen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "reclaim_reclaimed_pages_total"),
+			"Number of pages reclaimed.",
+			[]string{"mode"}, nil,
+		),
+		allocStalls: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, oomSubsystem, "allocation_stalls_total"),
+			"Number of times an allocation had to wait for direct reclaim.",
+			nil, nil,
+		),
+		cgroupPattern: pattern,
+		cgroupDepth:   *oomCgroupDepth,
+		victims:       oomVictimCache,
+		logger:        logger,
+	}, nil
+}
+
+func (c *oomCollector) Update(ch chan<- prometheus.Metric) error {
+	if err := c.updateVMStat(ch); err != nil {
+		return err
+	}
+	if err := c.updateCgroups(ch); err != nil {
+		return err
+	}
+	for k, v := range c.victims.counts() {
+		ch <- prometheus.MustNewConstMetric(c.victimKills, prometheus.CounterValue, float64(v), k.cgroup, k.comm)
+	}
+	return nil
+}
+
+func (c *oomCollector) updateVMStat(ch chan<- prometheus.Metric) error {
+	file, err := os.Open(procFilePath("vmstat"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stats, err := parseOOMVMStat(file)
+	if err != nil {
+		return err
+	}
+
+	if v, ok := stats["oom_kill"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.kills, prometheus.CounterValue, v)
+	}
+	for _, mode := range []string{"kswapd", "direct"} {
+		ch <- prometheus.MustNewConstMetric(c.reclaimScanned, prometheus.CounterValue, stats["pgscan_"+mode], mode)
+		ch <- prometheus.MustNewConstMetric(c.reclaimStolen, prometheus.CounterValue, stats["pgsteal_"+mode], mode)
+	}
+	ch <- prometheus.MustNewConstMetric(c.allocStalls, prometheus.CounterValue, stats["allocstall"])
+	return nil
+}
+
+// parseOOMVMStat parses /proc/vmstat, summing the per zone reclaim counters
+// of older kernels.
+func parseOOMVMStat(r io.Reader) (map[string]float64, error) {
+	stats := map[string]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		name := parts[0]
+		if name == "oom_kill" {
+			stats[name] = value
+			continue
+		}
+		for _, prefix := range []string{"pgscan_kswapd", "pgscan_direct", "pgsteal_kswapd", "pgsteal_direct", "allocstall"} {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if oomReclaimZones[strings.TrimPrefix(strings.TrimPrefix(name, prefix), "_")] {
+				stats[prefix] += value
+			}
+		}
+	}
+	return stats, scanner.Err()
+}
+
+func (c *oomCollector) updateCgroups(ch chan<- prometheus.Metric) error {
+	cgroups, err := c.cgroupMemoryEvents(sysFilePath("fs/cgroup"))
+	if err != nil {
+		return err
+	}
+	for cgroup, events := range cgroups {
+		for event, v := range events {
+			ch <- prometheus.MustNewConstMetric(c.cgroupEvents, prometheus.CounterValue, v, cgroup, event)
+		}
+	}
+	return nil
+}
+
+// cgroupMemoryEvents returns the memory events of the included cgroups of the
+// cgroup v2 hierarchy at root, by cgroup path.
+func (c *oomCollector) cgroupMemoryEvents(root string) (map[string]map[string]float64, error) {
+	cgroups := map[string]map[string]float64{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Cgroups may disappear while walking the hierarchy, and there
+			// may be no hierarchy at all.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			// Subtrees may be unreadable, e.g. those delegated to containers.
+			if os.IsPermission(err) && path != root {
+				level.Debug(c.logger).Log("msg", "skipping unreadable cgroup", "path", path, "err", err)
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			// Don't walk the cgroups of every pod and container if only
+			// the top-level cgroups are wanted.
+			if c.cgroupDepth > 0 && path != root {
+				rel, err := filepath.Rel(root, path)
+				if err != nil {
+					return err
+				}
+				if strings.Count(filepath.ToSlash(rel), "/")+1 > c.cgroupDepth {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if info.Name() != "memory.events" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		cgroup := "/"
+		if rel != "." {
+			cgroup += filepath.ToSlash(rel)
+		}
+		if !c.cgroupPattern.MatchString(cgroup) {
+			return nil
+		}
+
+		events, err := parseMemoryEvents(path)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read memory events", "cgroup", cgroup, "err", err)
+			return nil
+		}
+		cgroups[cgroup] = events
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cgroups, nil
+}
+
+// parseMemoryEvents parses the exported fields of a cgroup v2 memory.events
+// file.
+func parseMemoryEvents(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := map[string]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 || !oomCgroupEvents[parts[0]] {
+			continue
+		}
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		events[parts[0]] = v
+	}
+	return events, scanner.Err()
+}
+
+type oomVictimKey struct {
+	cgroup string
+	comm   string
+}
+
+type oomVictimEntry struct {
+	count    uint64
+	lastKill uint64
+}
+
+// oomVictims counts OOM kills from the kernel log by cgroup and command
+// name. Only the most recently killed combinations are kept.
+type oomVictims struct {
+	mtx     sync.Mutex
+	size    int
+	kills   uint64
+	entries map[oomVictimKey]*oomVictimEntry
+
+	// The cgroup of a victim is logged in a separate record preceding the
+	// kill.
+	pendingPID    string
+	pendingCgroup string
+}
+
+func newOOMVictims(size int) *oomVictims {
+	return &oomVictims{
+		size:    size,
+		entries: map[oomVictimKey]*oomVictimEntry{},
+	}
+}
+
+func (v *oomVictims) handle(r kmsgRecord) {
+	if m := oomKillPattern.FindStringSubmatch(r.message); m != nil {
+		v.mtx.Lock()
+		v.pendingCgroup, v.pendingPID = m[1], m[3]
+		v.mtx.Unlock()
+		return
+	}
+	if m := oomKilledPattern.FindStringSubmatch(r.message); m != nil {
+		v.add(m[1], m[2])
+	}
+}
+
+func (v *oomVictims) add(pid, comm string) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	key := oomVictimKey{comm: comm}
+	if pid == v.pendingPID {
+		key.cgroup = v.pendingCgroup
+	}
+	v.pendingPID, v.pendingCgroup = "", ""
+	v.kills++
+
+	if e, ok := v.entries[key]; ok {
+		e.count++
+		e.lastKill = v.kills
+		return
+	}
+	if v.size <= 0 {
+		return
+	}
+	if len(v.entries) >= v.size {
+		v.evict()
+	}
+	v.entries[key] = &oomVictimEntry{count: 1, lastKill: v.kills}
+}
+
+// evict removes the least recently killed entry.
+func (v *oomVictims) evict() {
+	var (
+		oldest oomVictimKey
+		min    uint64
+	)
+	for k, e := range v.entries {
+		if min == 0 || e.lastKill < min {
+			oldest, min = k, e.lastKill
+		}
+	}
+	delete(v.entries, oldest)
+}
+
+func (v *oomVictims) counts() map[oomVictimKey]uint64 {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	counts := make(map[oomVictimKey]uint64, len(v.entries))
+	for k, e := range v.entries {
+		counts[k] = e.count
+	}
+	return counts
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nooom
+
+package collector
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestParseOOMVMStat(t *testing.T) {
+	f, err := os.Open("fixtures/proc/vmstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stats, err := parseOOMVMStat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"oom_kill":       0,
+		"pgscan_kswapd":  466440,
+		"pgscan_direct":  6863,
+		"pgsteal_kswapd": 332911,
+		"pgsteal_direct": 6528,
+		"allocstall":     83165,
+	}
+	if !reflect.DeepEqual(want, stats) {
+		t.Errorf("want vmstat %v, got %v", want, stats)
+	}
+}
+
+func TestParseMemoryEvents(t *testing.T) {
+	events, err := parseMemoryEvents("fixtures/sys/fs/cgroup/system.slice/foo.service/memory.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"high": 12, "max": 3, "oom": 2, "oom_kill": 1}
+	if !reflect.DeepEqual(want, events) {
+		t.Errorf("want memory events %v, got %v", want, events)
+	}
+}
+
+func TestCgroupMemoryEvents(t *testing.T) {
+	for _, tc := range []struct {
+		include string
+		depth   int
+		want    []string
+	}{
+		{include: *oomCgroupInclude, depth: 1, want: []string{"/user.slice"}},
+		// Deeper cgroups are not walked, even if they would match.
+		{include: ".+", depth: 1, want: []string{"/user.slice"}},
+		{include: ".+", depth: 2, want: []string{"/system.slice/foo.service", "/user.slice"}},
+		{include: ".+", depth: 0, want: []string{"/system.slice/foo.service", "/user.slice"}},
+	} {
+		c := &oomCollector{cgroupPattern: regexp.MustCompile(tc.include), cgroupDepth: tc.depth, logger: log.NewNopLogger()}
+		cgroups, err := c.cgroupMemoryEvents("fixtures/sys/fs/cgroup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for cgroup := range cgroups {
+			got = append(got, cgroup)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("include %q, depth %d: want cgroups %v, got %v", tc.include, tc.depth, tc.want, got)
+		}
+	}
+}
+
+func TestOOMVictims(t *testing.T) {
+	f, err := os.Open("fixtures/kmsg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	victims := newOOMVictims(10)
+	if err := readKmsg(f, victims.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[oomVictimKey]uint64{
+		{cgroup: "", comm: "stress"}:                       1,
+		{cgroup: "/system.slice/foo.service", comm: "foo"}: 1,
+	}
+	if got := victims.counts(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want victims %v, got %v", want, got)
+	}
+}
+
+func TestOOMVictimsEviction(t *testing.T) {
+	victims := newOOMVictims(2)
+	for _, comm := range []string{"a", "b", "a", "c"} {
+		victims.add("1", comm)
+	}
+
+	want := map[oomVictimKey]uint64{
+		{comm: "a"}: 2,
+		{comm: "c"}: 1,
+	}
+	if got := victims.counts(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want victims %v, got %v", want, got)
+	}
+}
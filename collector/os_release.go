@@ -0,0 +1,138 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noos
+
+package collector
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// osReleaseFiles are tried in order, see os-release(5).
+	osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+
+	// osReleaseLabels maps os-release variables to the labels of os_info.
+	osReleaseLabels = []struct {
+		variable string
+		label    string
+	}{
+		{"NAME", "name"},
+		{"ID", "id"},
+		{"ID_LIKE", "id_like"},
+		{"PRETTY_NAME", "pretty_name"},
+		{"VERSION", "version"},
+		{"VERSION_ID", "version_id"},
+		{"VERSION_CODENAME", "version_codename"},
+		{"BUILD_ID", "build_id"},
+		{"VARIANT_ID", "variant_id"},
+	}
+)
+
+type osReleaseCollector struct {
+	info   *prometheus.Desc
+	logger log.Logger
+}
+
+func init() {
+	registerCollector("os", defaultEnabled, NewOSCollector)
+}
+
+// NewOSCollector returns a new Collector exposing os-release information.
+func NewOSCollector(logger log.Logger) (Collector, error) {
+	labels := make([]string, len(osReleaseLabels))
+	for i, l := range osReleaseLabels {
+		labels[i] = l.label
+	}
+	return &osReleaseCollector{
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "os", "info"),
+			"A metric with a constant '1' value labeled by the operating system identification from os-release.",
+			labels, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+func (c *osReleaseCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, name := range osReleaseFiles {
+		f, err := os.Open(rootfsFilePath(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		vars, err := parseOSRelease(f)
+		if err != nil {
+			return err
+		}
+		values := make([]string, len(osReleaseLabels))
+		for i, l := range osReleaseLabels {
+			values[i] = vars[l.variable]
+		}
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, values...)
+		return nil
+	}
+
+	level.Debug(c.logger).Log("msg", "no os-release file found")
+	return ErrNoData
+}
+
+// parseOSRelease parses the shell compatible variable assignments of an
+// os-release file.
+func parseOSRelease(r io.Reader) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		vars[parts[0]] = unquoteOSReleaseValue(parts[1])
+	}
+	return vars, scanner.Err()
+}
+
+// unquoteOSReleaseValue removes the quotes around a value and unescapes the
+// characters which have to be escaped in double quotes.
+func unquoteOSReleaseValue(v string) string {
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) && strings.IndexByte("\"\\$`", v[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noos
+
+package collector
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	f, err := os.Open("fixtures/usr/lib/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	vars, err := parseOSRelease(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{
+		"NAME":             "Ubuntu",
+		"ID":               "ubuntu",
+		"ID_LIKE":          "debian",
+		"VERSION":          "20.04.1 LTS (Focal Fossa)",
+		"VERSION_ID":       "20.04",
+		"VERSION_CODENAME": "focal",
+		"UBUNTU_CODENAME":  "focal",
+		"VARIANT":          `Server "Edition"`,
+	} {
+		if got := vars[k]; got != want {
+			t.Errorf("want %s %q, got %q", k, want, got)
+		}
+	}
+	if _, ok := vars[`# A comment with "quotes"`]; ok {
+		t.Error("comment parsed as variable")
+	}
+}
@@ -17,15 +17,19 @@ package collector
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
 	psiResources = []string{"cpu", "io", "memory"}
+
+	psiTriggerFlag = kingpin.Flag("collector.pressure.trigger", "PSI trigger to register, in the form [<cgroup>@]<resource>:<some|full>:<stall>:<window> (e.g. memory:some:150ms:1s). Can be repeated.").Strings()
 )
 
 type pressureStatsCollector struct {
@@ -35,6 +39,15 @@ type pressureStatsCollector struct {
 	mem     *prometheus.Desc
 	memFull *prometheus.Desc
 
+	cpuAvg     *prometheus.Desc
+	ioAvg      *prometheus.Desc
+	ioFullAvg  *prometheus.Desc
+	memAvg     *prometheus.Desc
+	memFullAvg *prometheus.Desc
+
+	triggerEvents *prometheus.Desc
+	triggers      []*psiTrigger
+
 	fs procfs.FS
 
 	logger log.Logger
@@ -51,6 +64,11 @@ func NewPressureStatsCollector(logger log.Logger) (Collector, error) {
 		return nil, fmt.Errorf("failed to open procfs: %w", err)
 	}
 
+	triggers, err := startPSITriggers(*psiTriggerFlag, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	return &pressureStatsCollector{
 		cpu: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "pressure", "cpu_waiting_seconds_total"),
@@ -77,13 +95,49 @@ func NewPressureStatsCollector(logger log.Logger) (Collector, error) {
 			"Total time in seconds no process could make progress due to memory congestion",
 			nil, nil,
 		),
-		fs:     fs,
-		logger: logger,
+		cpuAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "cpu_waiting_ratio"),
+			"Share of time over the averaging window that processes have waited for CPU time",
+			[]string{"window"}, nil,
+		),
+		ioAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "io_waiting_ratio"),
+			"Share of time over the averaging window that processes have waited due to IO congestion",
+			[]string{"window"}, nil,
+		),
+		ioFullAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "io_stalled_ratio"),
+			"Share of time over the averaging window no process could make progress due to IO congestion",
+			[]string{"window"}, nil,
+		),
+		memAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "memory_waiting_ratio"),
+			"Share of time over the averaging window that processes have waited for memory",
+			[]string{"window"}, nil,
+		),
+		memFullAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "memory_stalled_ratio"),
+			"Share of time over the averaging window no process could make progress due to memory congestion",
+			[]string{"window"}, nil,
+		),
+		triggerEvents: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pressure", "trigger_events_total"),
+			"Number of times a registered PSI trigger threshold was breached",
+			[]string{"cgroup", "resource", "type", "stall", "window"}, nil,
+		),
+		triggers: triggers,
+		fs:       fs,
+		logger:   logger,
 	}, nil
 }
 
 // Update calls procfs.NewPSIStatsForResource for the different resources and updates the values
 func (c *pressureStatsCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, t := range c.triggers {
+		ch <- prometheus.MustNewConstMetric(c.triggerEvents, prometheus.CounterValue, float64(atomic.LoadUint64(&t.events)),
+			t.cgroupLabel(), t.resource, t.kind, t.stall.String(), t.window.String())
+	}
+
 	for _, res := range psiResources {
 		level.Debug(c.logger).Log("msg", "collecting statistics for resource", "resource", res)
 		vals, err := c.fs.PSIStatsForResource(res)
@@ -94,12 +148,17 @@ func (c *pressureStatsCollector) Update(ch chan<- prometheus.Metric) error {
 		switch res {
 		case "cpu":
 			ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.CounterValue, float64(vals.Some.Total)/1000.0/1000.0)
+			c.updateAverages(ch, c.cpuAvg, vals.Some)
 		case "io":
 			ch <- prometheus.MustNewConstMetric(c.io, prometheus.CounterValue, float64(vals.Some.Total)/1000.0/1000.0)
 			ch <- prometheus.MustNewConstMetric(c.ioFull, prometheus.CounterValue, float64(vals.Full.Total)/1000.0/1000.0)
+			c.updateAverages(ch, c.ioAvg, vals.Some)
+			c.updateAverages(ch, c.ioFullAvg, vals.Full)
 		case "memory":
 			ch <- prometheus.MustNewConstMetric(c.mem, prometheus.CounterValue, float64(vals.Some.Total)/1000.0/1000.0)
 			ch <- prometheus.MustNewConstMetric(c.memFull, prometheus.CounterValue, float64(vals.Full.Total)/1000.0/1000.0)
+			c.updateAverages(ch, c.memAvg, vals.Some)
+			c.updateAverages(ch, c.memFullAvg, vals.Full)
 		default:
 			level.Debug(c.logger).Log("msg", "did not account for resource", "resource", res)
 		}
@@ -107,3 +166,14 @@ func (c *pressureStatsCollector) Update(ch chan<- prometheus.Metric) error {
 
 	return nil
 }
+
+// updateAverages exports the avg10, avg60 and avg300 fields of a PSI line.
+// The kernel reports them as percentages, they are exported as ratios.
+func (c *pressureStatsCollector) updateAverages(ch chan<- prometheus.Metric, desc *prometheus.Desc, line *procfs.PSILine) {
+	if line == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, line.Avg10/100.0, "10s")
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, line.Avg60/100.0, "60s")
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, line.Avg300/100.0, "300s")
+}
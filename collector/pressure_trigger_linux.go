@@ -0,0 +1,187 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nopressure
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// The registered triggers, whose file descriptors stay open for the
+	// lifetime of the process.
+	psiTriggersOnce sync.Once
+	psiTriggers     []*psiTrigger
+	psiTriggersErr  error
+)
+
+// psiTrigger is a PSI trigger registered on a pressure file. The kernel
+// wakes up pollers with POLLPRI whenever the stall time within window
+// exceeds stall, which is counted in events.
+type psiTrigger struct {
+	events uint64 // Must stay first for atomic alignment on 32-bit platforms.
+
+	cgroup   string
+	resource string
+	kind     string
+	stall    time.Duration
+	window   time.Duration
+
+	file *os.File
+}
+
+// parsePSITrigger parses a trigger in the form
+// [<cgroup>@]<resource>:<some|full>:<stall>:<window>.
+func parsePSITrigger(spec string) (*psiTrigger, error) {
+	t := &psiTrigger{}
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		t.cgroup = strings.Trim(spec[:i], "/")
+		spec = spec[i+1:]
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid PSI trigger %q", spec)
+	}
+	t.resource, t.kind = parts[0], parts[1]
+
+	switch t.resource {
+	case "cpu", "io", "memory":
+	default:
+		return nil, fmt.Errorf("invalid PSI trigger resource %q", t.resource)
+	}
+	switch t.kind {
+	case "some", "full":
+	default:
+		return nil, fmt.Errorf("invalid PSI trigger type %q", t.kind)
+	}
+
+	var err error
+	if t.stall, err = time.ParseDuration(parts[2]); err != nil {
+		return nil, fmt.Errorf("invalid PSI trigger stall %q: %w", parts[2], err)
+	}
+	if t.window, err = time.ParseDuration(parts[3]); err != nil {
+		return nil, fmt.Errorf("invalid PSI trigger window %q: %w", parts[3], err)
+	}
+	// These limits are enforced by the kernel, see Documentation/accounting/psi.rst.
+	if t.window < 500*time.Millisecond || t.window > 10*time.Second {
+		return nil, fmt.Errorf("PSI trigger window %s must be between 500ms and 10s", t.window)
+	}
+	if t.stall <= 0 || t.stall > t.window {
+		return nil, fmt.Errorf("PSI trigger stall %s must be positive and not exceed the window", t.stall)
+	}
+
+	return t, nil
+}
+
+// path returns the pressure file the trigger is registered on.
+func (t *psiTrigger) path() string {
+	if t.cgroup == "" {
+		return procFilePath(filepath.Join("pressure", t.resource))
+	}
+	return sysFilePath(filepath.Join("fs/cgroup", t.cgroup, t.resource+".pressure"))
+}
+
+// cgroupLabel returns the cgroup the trigger applies to, "/" being the
+// system wide pressure.
+func (t *psiTrigger) cgroupLabel() string {
+	return "/" + t.cgroup
+}
+
+// register writes the trigger to its pressure file. The file must be kept
+// open for the trigger to stay active.
+func (t *psiTrigger) register() error {
+	f, err := os.OpenFile(t.path(), os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	cfg := fmt.Sprintf("%s %d %d\x00", t.kind, t.stall.Microseconds(), t.window.Microseconds())
+	if _, err := f.Write([]byte(cfg)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to register PSI trigger on %s: %w", t.path(), err)
+	}
+	t.file = f
+	return nil
+}
+
+// watch polls the pressure file and counts trigger events until the file
+// reports an error, e.g. because the cgroup was removed.
+func (t *psiTrigger) watch(logger log.Logger) {
+	defer t.file.Close()
+
+	fds := []unix.PollFd{{Fd: int32(t.file.Fd()), Events: unix.POLLPRI}}
+	for {
+		n, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to poll PSI trigger", "path", t.path(), "err", err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		if fds[0].Revents&unix.POLLERR != 0 {
+			level.Warn(logger).Log("msg", "PSI trigger is no longer available", "path", t.path())
+			return
+		}
+		if fds[0].Revents&unix.POLLPRI != 0 {
+			atomic.AddUint64(&t.events, 1)
+		}
+	}
+}
+
+// startPSITriggers registers the configured triggers and starts watching
+// them. It only does so once per process.
+func startPSITriggers(specs []string, logger log.Logger) ([]*psiTrigger, error) {
+	psiTriggersOnce.Do(func() {
+		triggers := make([]*psiTrigger, 0, len(specs))
+		for _, spec := range specs {
+			t, err := parsePSITrigger(spec)
+			if err != nil {
+				psiTriggersErr = err
+				return
+			}
+			triggers = append(triggers, t)
+		}
+		for i, t := range triggers {
+			if err := t.register(); err != nil {
+				for _, r := range triggers[:i] {
+					r.file.Close()
+				}
+				psiTriggersErr = err
+				return
+			}
+		}
+		for _, t := range triggers {
+			level.Debug(logger).Log("msg", "registered PSI trigger", "path", t.path(), "type", t.kind, "stall", t.stall, "window", t.window)
+			go t.watch(logger)
+		}
+		psiTriggers = triggers
+	})
+	return psiTriggers, psiTriggersErr
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nopressure
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestParsePSITrigger(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc", "--path.sysfs", "fixtures/sys"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec     string
+		path     string
+		cgroup   string
+		resource string
+		kind     string
+		stall    time.Duration
+		window   time.Duration
+		err      bool
+	}{
+		{
+			spec:     "memory:some:150ms:1s",
+			path:     "fixtures/proc/pressure/memory",
+			cgroup:   "/",
+			resource: "memory",
+			kind:     "some",
+			stall:    150 * time.Millisecond,
+			window:   time.Second,
+		},
+		{
+			spec:     "/system.slice/foo.service@io:full:1s:10s",
+			path:     "fixtures/sys/fs/cgroup/system.slice/foo.service/io.pressure",
+			cgroup:   "/system.slice/foo.service",
+			resource: "io",
+			kind:     "full",
+			stall:    time.Second,
+			window:   10 * time.Second,
+		},
+		{spec: "memory:some:150ms", err: true},
+		{spec: "disk:some:150ms:1s", err: true},
+		{spec: "cpu:most:150ms:1s", err: true},
+		{spec: "cpu:some:2s:1s", err: true},
+		{spec: "cpu:some:100ms:100ms", err: true},
+		{spec: "cpu:some:150:1s", err: true},
+	}
+
+	for _, test := range tests {
+		trigger, err := parsePSITrigger(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got none", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.spec, err)
+			continue
+		}
+		if got := trigger.path(); got != test.path {
+			t.Errorf("%s: want path %q, got %q", test.spec, test.path, got)
+		}
+		if got := trigger.cgroupLabel(); got != test.cgroup {
+			t.Errorf("%s: want cgroup %q, got %q", test.spec, test.cgroup, got)
+		}
+		if trigger.resource != test.resource || trigger.kind != test.kind {
+			t.Errorf("%s: want %s/%s, got %s/%s", test.spec, test.resource, test.kind, trigger.resource, trigger.kind)
+		}
+		if trigger.stall != test.stall || trigger.window != test.window {
+			t.Errorf("%s: want %s/%s, got %s/%s", test.spec, test.stall, test.window, trigger.stall, trigger.window)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noroute
+
+package collector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var routeFixtures = kingpin.Flag("collector.route.fixtures", "test fixtures to use for route collector metrics").Default("").Hidden().String()
+
+type routeCollector struct {
+	entries        *prometheus.Desc
+	defaultGateway *prometheus.Desc
+	ecmpEntries    *prometheus.Desc
+	ecmpNexthops   *prometheus.Desc
+	rules          *prometheus.Desc
+	logger         log.Logger
+}
+
+func init() {
+	registerCollector("route", defaultDisabled, NewRouteCollector)
+}
+
+// NewRouteCollector returns a new Collector exposing routing table and policy
+// rule stats.
+func NewRouteCollector(logger log.Logger) (Collector, error) {
+	const subsystem = "route"
+
+	return &routeCollector{
+		entries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "entries"),
+			"Number of routes by table, address family and protocol.",
+			[]string{"table", "family", "protocol"}, nil,
+		),
+		defaultGateway: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "default_gateway_info"),
+			"Nexthops of default routes, with a value of 1.",
+			[]string{"table", "family", "gateway", "device"}, nil,
+		),
+		ecmpEntries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "ecmp_entries"),
+			"Number of routes with more than one nexthop by table and address family.",
+			[]string{"table", "family"}, nil,
+		),
+		ecmpNexthops: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "ecmp_nexthops"),
+			"Number of nexthops of routes with more than one nexthop by table and address family.",
+			[]string{"table", "family"}, nil,
+		),
+		rules: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "rules"),
+			"Number of policy routing rules by address family.",
+			[]string{"family"}, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+// routeNexthop is a nexthop of a route. Gateway is empty for directly
+// connected routes.
+type routeNexthop struct {
+	Gateway string
+	Device  string
+}
+
+// routeEntry is a route of a routing table.
+type routeEntry struct {
+	Table    string
+	Family   string
+	Protocol string
+	Default  bool
+	Nexthops []routeNexthop
+}
+
+// routeTables are the routes of all routing tables and the number of policy
+// routing rules by address family. Rules is nil if the rules are unknown.
+type routeTables struct {
+	Routes []routeEntry
+	Rules  map[string]uint64
+}
+
+type routeEntryKey struct {
+	table    string
+	family   string
+	protocol string
+}
+
+type routeGatewayKey struct {
+	table   string
+	family  string
+	gateway string
+	device  string
+}
+
+type routeTableKey struct {
+	table  string
+	family string
+}
+
+func (c *routeCollector) Update(ch chan<- prometheus.Metric) error {
+	tables, err := c.routeTables()
+	if err != nil {
+		return fmt.Errorf("could not get routing tables: %w", err)
+	}
+
+	entries := map[routeEntryKey]uint64{}
+	// The same default gateway may be used by several routes, e.g. with
+	// different metrics.
+	gateways := map[routeGatewayKey]struct{}{}
+	ecmpEntries := map[routeTableKey]uint64{}
+	ecmpNexthops := map[routeTableKey]uint64{}
+	for _, r := range tables.Routes {
+		entries[routeEntryKey{r.Table, r.Family, r.Protocol}]++
+		if len(r.Nexthops) > 1 {
+			ecmpEntries[routeTableKey{r.Table, r.Family}]++
+			ecmpNexthops[routeTableKey{r.Table, r.Family}] += uint64(len(r.Nexthops))
+		}
+		if r.Default {
+			for _, nh := range r.Nexthops {
+				gateways[routeGatewayKey{r.Table, r.Family, nh.Gateway, nh.Device}] = struct{}{}
+			}
+		}
+	}
+	for k := range gateways {
+		ch <- prometheus.MustNewConstMetric(c.defaultGateway, prometheus.GaugeValue, 1, k.table, k.family, k.gateway, k.device)
+	}
+	for k, v := range entries {
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(v), k.table, k.family, k.protocol)
+	}
+	for k, v := range ecmpEntries {
+		ch <- prometheus.MustNewConstMetric(c.ecmpEntries, prometheus.GaugeValue, float64(v), k.table, k.family)
+		ch <- prometheus.MustNewConstMetric(c.ecmpNexthops, prometheus.GaugeValue, float64(ecmpNexthops[k]), k.table, k.family)
+	}
+	for family, v := range tables.Rules {
+		ch <- prometheus.MustNewConstMetric(c.rules, prometheus.GaugeValue, float64(v), family)
+	}
+	return nil
+}
+
+// routeTables returns the routes and policy routing rules. It falls back to
+// /proc/net/route and /proc/net/ipv6_route, which have no protocols and
+// rules, if the routes can't be dumped via netlink.
+func (c *routeCollector) routeTables() (*routeTables, error) {
+	if *routeFixtures != "" {
+		return readRouteFixtures(*routeFixtures)
+	}
+
+	tables, err := dumpRouteTables()
+	if err == nil {
+		return tables, nil
+	}
+	level.Debug(c.logger).Log("msg", "failed to dump routing tables via netlink, falling back to /proc/net/route", "err", err)
+
+	tables = &routeTables{}
+	for _, f := range []struct {
+		name  string
+		parse func(io.Reader) ([]routeEntry, error)
+	}{
+		{"net/route", parseProcRoutes},
+		{"net/ipv6_route", parseProcIPv6Routes},
+	} {
+		routes, err := readProcRoutes(procFilePath(f.name), f.parse)
+		if err != nil {
+			return nil, err
+		}
+		tables.Routes = append(tables.Routes, routes...)
+	}
+	return tables, nil
+}
+
+func readProcRoutes(path string, parse func(io.Reader) ([]routeEntry, error)) ([]routeEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse(file)
+}
+
+// Route flags of /proc/net/route and /proc/net/ipv6_route, see
+// include/uapi/linux/route.h and include/uapi/linux/ipv6_route.h.
+const (
+	rtfGateway = 0x0002
+	rtfReject  = 0x0200
+	rtfCache   = 0x01000000
+)
+
+// parseProcRoutes parses /proc/net/route, which only has the routes of the
+// main table.
+func parseProcRoutes(r io.Reader) ([]routeEntry, error) {
+	scanner := bufio.NewScanner(r)
+	// Skip the header line.
+	scanner.Scan()
+
+	var routes []routeEntry
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 11 {
+			return nil, fmt.Errorf("invalid route line %q", scanner.Text())
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route flags %q: %w", fields[3], err)
+		}
+
+		// Addresses are in host byte order.
+		nh := routeNexthop{Device: fields[0]}
+		if flags&rtfGateway != 0 {
+			gw, err := strconv.ParseUint(fields[2], 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route gateway %q: %w", fields[2], err)
+			}
+			ip := make(net.IP, net.IPv4len)
+			binary.LittleEndian.PutUint32(ip, uint32(gw))
+			nh.Gateway = ip.String()
+		}
+		routes = append(routes, routeEntry{
+			Table:    "main",
+			Family:   "ipv4",
+			Protocol: "unknown",
+			Default:  fields[1] == "00000000" && fields[7] == "00000000",
+			Nexthops: []routeNexthop{nh},
+		})
+	}
+	return routes, scanner.Err()
+}
+
+// parseProcIPv6Routes parses /proc/net/ipv6_route, which has the routes of all
+// tables without telling which.
+func parseProcIPv6Routes(r io.Reader) ([]routeEntry, error) {
+	scanner := bufio.NewScanner(r)
+
+	var routes []routeEntry
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 10 {
+			return nil, fmt.Errorf("invalid IPv6 route line %q", scanner.Text())
+		}
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 route flags %q: %w", fields[8], err)
+		}
+		// Unreachable routes, such as the default route the kernel adds on lo
+		// without IPv6 connectivity, are not routes to alert on. The netlink
+		// dump skips them by only keeping unicast routes.
+		if flags&(rtfCache|rtfReject) != 0 {
+			continue
+		}
+
+		nh := routeNexthop{Device: fields[9]}
+		if flags&rtfGateway != 0 {
+			gw, err := hex.DecodeString(fields[4])
+			if err != nil || len(gw) != net.IPv6len {
+				return nil, fmt.Errorf("invalid IPv6 route gateway %q", fields[4])
+			}
+			nh.Gateway = net.IP(gw).String()
+		}
+		routes = append(routes, routeEntry{
+			Table:    "unknown",
+			Family:   "ipv6",
+			Protocol: "unknown",
+			Default:  fields[1] == "00",
+			Nexthops: []routeNexthop{nh},
+		})
+	}
+	return routes, scanner.Err()
+}
+
+func readRouteFixtures(fixtures string) (*routeTables, error) {
+	b, err := ioutil.ReadFile(filepath.Join(fixtures, "routes.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var tables routeTables
+	if err := json.Unmarshal(b, &tables); err != nil {
+		return nil, err
+	}
+	return &tables, nil
+}
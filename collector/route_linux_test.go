@@ -0,0 +1,136 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noroute
+
+package collector
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseProcRoutes(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		parse func(io.Reader) ([]routeEntry, error)
+		want  []routeEntry
+	}{
+		{
+			path:  "fixtures/proc/net/route",
+			parse: parseProcRoutes,
+			want: []routeEntry{
+				{Table: "main", Family: "ipv4", Protocol: "unknown", Default: true, Nexthops: []routeNexthop{{Gateway: "192.168.1.1", Device: "eth0"}}},
+				{Table: "main", Family: "ipv4", Protocol: "unknown", Nexthops: []routeNexthop{{Device: "eth0"}}},
+				{Table: "main", Family: "ipv4", Protocol: "unknown", Nexthops: []routeNexthop{{Device: "docker0"}}},
+			},
+		},
+		{
+			path:  "fixtures/proc/net/ipv6_route",
+			parse: parseProcIPv6Routes,
+			want: []routeEntry{
+				{Table: "unknown", Family: "ipv6", Protocol: "unknown", Nexthops: []routeNexthop{{Device: "eth0"}}},
+				{Table: "unknown", Family: "ipv6", Protocol: "unknown", Nexthops: []routeNexthop{{Device: "eth0"}}},
+				{Table: "unknown", Family: "ipv6", Protocol: "unknown", Default: true, Nexthops: []routeNexthop{{Gateway: "fe80::1", Device: "eth0"}}},
+				{Table: "unknown", Family: "ipv6", Protocol: "unknown", Nexthops: []routeNexthop{{Device: "lo"}}},
+			},
+		},
+	} {
+		routes, err := readProcRoutes(test.path, test.parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(routes, test.want) {
+			t.Errorf("%s: want %+v, got %+v", test.path, test.want, routes)
+		}
+	}
+}
+
+// routeAttribute encodes a route attribute, padded to 4 bytes.
+func routeAttribute(typ uint16, data []byte) []byte {
+	b := append(nlenc.Uint16Bytes(uint16(4+len(data))), nlenc.Uint16Bytes(typ)...)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// routeMessage encodes a struct rtmsg followed by attrs.
+func routeMessage(family, dstLen, table, protocol, typ uint8, flags uint32, attrs ...[]byte) netlink.Message {
+	data := []byte{family, dstLen, 0, 0, table, protocol, unix.RT_SCOPE_UNIVERSE, typ}
+	data = append(data, nlenc.Uint32Bytes(flags)...)
+	for _, a := range attrs {
+		data = append(data, a...)
+	}
+	return netlink.Message{Header: netlink.Header{Type: unix.RTM_NEWROUTE}, Data: data}
+}
+
+// routeNexthopBytes encodes a struct rtnexthop with an RTA_GATEWAY attribute.
+func routeNexthopBytes(index int32, gateway net.IP) []byte {
+	gw := routeAttribute(unix.RTA_GATEWAY, gateway)
+	b := append(nlenc.Uint16Bytes(uint16(unix.SizeofRtNexthop+len(gw))), 0, 0)
+	b = append(b, nlenc.Int32Bytes(index)...)
+	return append(b, gw...)
+}
+
+func TestParseRouteMessages(t *testing.T) {
+	msgs := []netlink.Message{
+		routeMessage(unix.AF_INET, 0, unix.RT_TABLE_MAIN, unix.RTPROT_DHCP, unix.RTN_UNICAST, 0,
+			routeAttribute(unix.RTA_TABLE, nlenc.Uint32Bytes(unix.RT_TABLE_MAIN)),
+			routeAttribute(unix.RTA_GATEWAY, net.ParseIP("192.168.1.1").To4()),
+			routeAttribute(unix.RTA_OIF, nlenc.Uint32Bytes(2)),
+		),
+		// Tables above 255 are only in RTA_TABLE.
+		routeMessage(unix.AF_INET, 0, unix.RT_TABLE_COMPAT, unix.RTPROT_BIRD, unix.RTN_UNICAST, 0,
+			routeAttribute(unix.RTA_TABLE, nlenc.Uint32Bytes(1000)),
+			routeAttribute(unix.RTA_MULTIPATH, append(
+				routeNexthopBytes(2, net.ParseIP("10.0.0.1").To4()),
+				routeNexthopBytes(3, net.ParseIP("10.0.1.1").To4())...,
+			)),
+		),
+		routeMessage(unix.AF_INET6, 64, unix.RT_TABLE_MAIN, unix.RTPROT_KERNEL, unix.RTN_UNICAST, 0,
+			routeAttribute(unix.RTA_OIF, nlenc.Uint32Bytes(2)),
+		),
+		routeMessage(unix.AF_INET6, 0, unix.RT_TABLE_MAIN, 99, unix.RTN_UNREACHABLE, 0,
+			routeAttribute(unix.RTA_OIF, nlenc.Uint32Bytes(1)),
+		),
+		// Cloned routes are skipped.
+		routeMessage(unix.AF_INET6, 128, unix.RT_TABLE_MAIN, unix.RTPROT_KERNEL, unix.RTN_UNICAST, unix.RTM_F_CLONED,
+			routeAttribute(unix.RTA_OIF, nlenc.Uint32Bytes(2)),
+		),
+	}
+	routes, err := parseRouteMessages(msgs, map[int]string{1: "lo", 2: "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []routeEntry{
+		{Table: "main", Family: "ipv4", Protocol: "dhcp", Default: true, Nexthops: []routeNexthop{{Gateway: "192.168.1.1", Device: "eth0"}}},
+		{Table: "1000", Family: "ipv4", Protocol: "bird", Default: true, Nexthops: []routeNexthop{{Gateway: "10.0.0.1", Device: "eth0"}, {Gateway: "10.0.1.1", Device: "3"}}},
+		{Table: "main", Family: "ipv6", Protocol: "kernel", Nexthops: []routeNexthop{{Device: "eth0"}}},
+		{Table: "main", Family: "ipv6", Protocol: "99", Nexthops: []routeNexthop{{Device: "lo"}}},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("want %+v, got %+v", want, routes)
+	}
+
+	if _, err := parseRouteMessages([]netlink.Message{{Data: []byte{unix.AF_INET}}}, nil); err == nil {
+		t.Error("expected error for short message, got none")
+	}
+}
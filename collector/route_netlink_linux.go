@@ -0,0 +1,227 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !noroute
+
+package collector
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// Size of struct fib_rule_hdr, which precedes the attributes of rule messages.
+const fibRuleHdrLen = 12
+
+var routeFamilyNames = map[uint8]string{
+	unix.AF_INET:  "ipv4",
+	unix.AF_INET6: "ipv6",
+}
+
+var routeTableNames = map[uint32]string{
+	unix.RT_TABLE_DEFAULT: "default",
+	unix.RT_TABLE_MAIN:    "main",
+	unix.RT_TABLE_LOCAL:   "local",
+}
+
+// routeProtocolNames are the names of route protocols, as in
+// /etc/iproute2/rt_protos.
+var routeProtocolNames = map[uint8]string{
+	unix.RTPROT_UNSPEC:   "unspec",
+	unix.RTPROT_REDIRECT: "redirect",
+	unix.RTPROT_KERNEL:   "kernel",
+	unix.RTPROT_BOOT:     "boot",
+	unix.RTPROT_STATIC:   "static",
+	unix.RTPROT_GATED:    "gated",
+	unix.RTPROT_RA:       "ra",
+	unix.RTPROT_MRT:      "mrt",
+	unix.RTPROT_ZEBRA:    "zebra",
+	unix.RTPROT_BIRD:     "bird",
+	unix.RTPROT_DNROUTED: "dnrouted",
+	unix.RTPROT_XORP:     "xorp",
+	unix.RTPROT_NTK:      "ntk",
+	unix.RTPROT_DHCP:     "dhcp",
+	unix.RTPROT_MROUTED:  "mrouted",
+	unix.RTPROT_BABEL:    "babel",
+	unix.RTPROT_BGP:      "bgp",
+	unix.RTPROT_ISIS:     "isis",
+	unix.RTPROT_OSPF:     "ospf",
+	unix.RTPROT_RIP:      "rip",
+	unix.RTPROT_EIGRP:    "eigrp",
+}
+
+// dumpRouteTables returns the IPv4 and IPv6 routes of all routing tables and
+// the number of policy routing rules.
+func dumpRouteTables() (*routeTables, error) {
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	routeMsgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETROUTE,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: make([]byte, unix.SizeofRtMsg),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ruleMsgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  unix.RTM_GETRULE,
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: make([]byte, fibRuleHdrLen),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[int]string, len(ifis))
+	for _, ifi := range ifis {
+		devices[ifi.Index] = ifi.Name
+	}
+
+	routes, err := parseRouteMessages(routeMsgs, devices)
+	if err != nil {
+		return nil, err
+	}
+	rules := map[string]uint64{}
+	for _, m := range ruleMsgs {
+		if len(m.Data) < fibRuleHdrLen {
+			return nil, fmt.Errorf("short rule message of %d bytes", len(m.Data))
+		}
+		if family, ok := routeFamilyNames[m.Data[0]]; ok {
+			rules[family]++
+		}
+	}
+	return &routeTables{Routes: routes, Rules: rules}, nil
+}
+
+// parseRouteMessages parses RTM_NEWROUTE messages, which start with a struct
+// rtmsg. Cloned routes and routes of other families than IPv4 and IPv6 are
+// skipped.
+func parseRouteMessages(msgs []netlink.Message, devices map[int]string) ([]routeEntry, error) {
+	var routes []routeEntry
+	for _, m := range msgs {
+		if len(m.Data) < unix.SizeofRtMsg {
+			return nil, fmt.Errorf("short route message of %d bytes", len(m.Data))
+		}
+		family, ok := routeFamilyNames[m.Data[0]]
+		if !ok || nlenc.Uint32(m.Data[8:12])&unix.RTM_F_CLONED != 0 {
+			continue
+		}
+
+		table := uint32(m.Data[4])
+		var (
+			nh       routeNexthop
+			nexthops []routeNexthop
+		)
+		ad, err := netlink.NewAttributeDecoder(m.Data[unix.SizeofRtMsg:])
+		if err != nil {
+			return nil, err
+		}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.RTA_TABLE:
+				table = ad.Uint32()
+			case unix.RTA_GATEWAY:
+				nh.Gateway = net.IP(ad.Bytes()).String()
+			case unix.RTA_OIF:
+				nh.Device = routeDevice(devices, int(ad.Uint32()))
+			case unix.RTA_MULTIPATH:
+				nexthops, err = parseRouteNexthops(ad.Bytes(), devices)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if nexthops == nil {
+			nexthops = []routeNexthop{nh}
+		}
+
+		tableName, ok := routeTableNames[table]
+		if !ok {
+			tableName = strconv.FormatUint(uint64(table), 10)
+		}
+		protocol, ok := routeProtocolNames[m.Data[5]]
+		if !ok {
+			protocol = strconv.Itoa(int(m.Data[5]))
+		}
+		routes = append(routes, routeEntry{
+			Table:    tableName,
+			Family:   family,
+			Protocol: protocol,
+			Default:  m.Data[1] == 0 && m.Data[7] == unix.RTN_UNICAST,
+			Nexthops: nexthops,
+		})
+	}
+	return routes, nil
+}
+
+// parseRouteNexthops parses the struct rtnexthop list of an RTA_MULTIPATH
+// attribute, each of which is followed by its own attributes.
+func parseRouteNexthops(b []byte, devices map[int]string) ([]routeNexthop, error) {
+	var nexthops []routeNexthop
+	for len(b) >= unix.SizeofRtNexthop {
+		size := int(nlenc.Uint16(b[0:2]))
+		if size < unix.SizeofRtNexthop || size > len(b) {
+			return nil, fmt.Errorf("invalid nexthop size %d", size)
+		}
+		nh := routeNexthop{Device: routeDevice(devices, int(nlenc.Int32(b[4:8])))}
+
+		ad, err := netlink.NewAttributeDecoder(b[unix.SizeofRtNexthop:size])
+		if err != nil {
+			return nil, err
+		}
+		for ad.Next() {
+			if ad.Type() == unix.RTA_GATEWAY {
+				nh.Gateway = net.IP(ad.Bytes()).String()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		nexthops = append(nexthops, nh)
+
+		// Nexthops are aligned to 4 bytes.
+		size = (size + 3) &^ 3
+		if size > len(b) {
+			break
+		}
+		b = b[size:]
+	}
+	return nexthops, nil
+}
+
+func routeDevice(devices map[int]string, index int) string {
+	if device, ok := devices[index]; ok {
+		return device
+	}
+	return strconv.Itoa(index)
+}
@@ -16,20 +16,31 @@
 package collector
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/procfs"
 )
 
+// softnetSysctls are the net.core sysctls which limit softirq packet
+// processing.
+var softnetSysctls = []string{"netdev_budget", "netdev_budget_usecs", "netdev_max_backlog", "rps_sock_flow_entries"}
+
 type softnetCollector struct {
-	fs           procfs.FS
-	processed    *prometheus.Desc
-	dropped      *prometheus.Desc
-	timeSqueezed *prometheus.Desc
-	logger       log.Logger
+	processed      *prometheus.Desc
+	dropped        *prometheus.Desc
+	timeSqueezed   *prometheus.Desc
+	receivedRPS    *prometheus.Desc
+	flowLimitCount *prometheus.Desc
+	backlogLen     *prometheus.Desc
+	sysctls        map[string]*prometheus.Desc
+	logger         log.Logger
 }
 
 const (
@@ -42,13 +53,16 @@ func init() {
 
 // NewSoftnetCollector returns a new Collector exposing softnet metrics.
 func NewSoftnetCollector(logger log.Logger) (Collector, error) {
-	fs, err := procfs.NewFS(*procPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	sysctls := make(map[string]*prometheus.Desc, len(softnetSysctls))
+	for _, name := range softnetSysctls {
+		sysctls[name] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, softnetSubsystem, name),
+			fmt.Sprintf("Value of the net.core.%s sysctl.", name),
+			nil, nil,
+		)
 	}
 
 	return &softnetCollector{
-		fs: fs,
 		processed: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, softnetSubsystem, "processed_total"),
 			"Number of processed packets",
@@ -64,19 +78,35 @@ func NewSoftnetCollector(logger log.Logger) (Collector, error) {
 			"Number of times processing packets ran out of quota",
 			[]string{"cpu"}, nil,
 		),
-		logger: logger,
+		receivedRPS: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, softnetSubsystem, "received_rps_total"),
+			"Number of times the CPU was woken up by an inter-processor interrupt to process packets steered by RPS",
+			[]string{"cpu"}, nil,
+		),
+		flowLimitCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, softnetSubsystem, "flow_limit_count_total"),
+			"Number of packets dropped by the flow limit",
+			[]string{"cpu"}, nil,
+		),
+		backlogLen: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, softnetSubsystem, "backlog_len"),
+			"Number of packets in the backlog queues",
+			[]string{"cpu"}, nil,
+		),
+		sysctls: sysctls,
+		logger:  logger,
 	}, nil
 }
 
-// Update gets parsed softnet statistics using procfs.
+// Update gets parsed softnet statistics from /proc/net/softnet_stat.
 func (c *softnetCollector) Update(ch chan<- prometheus.Metric) error {
-	stats, err := c.fs.NetSoftnetStat()
+	stats, err := readSoftnetStats(procFilePath("net/softnet_stat"))
 	if err != nil {
 		return fmt.Errorf("could not get softnet statistics: %w", err)
 	}
 
-	for cpuNumber, cpuStats := range stats {
-		cpu := strconv.Itoa(cpuNumber)
+	for _, cpuStats := range stats {
+		cpu := strconv.Itoa(int(cpuStats.CPU))
 
 		ch <- prometheus.MustNewConstMetric(
 			c.processed,
@@ -96,7 +126,93 @@ func (c *softnetCollector) Update(ch chan<- prometheus.Metric) error {
 			float64(cpuStats.TimeSqueezed),
 			cpu,
 		)
+		if cpuStats.ReceivedRPS != nil {
+			ch <- prometheus.MustNewConstMetric(c.receivedRPS, prometheus.CounterValue, float64(*cpuStats.ReceivedRPS), cpu)
+		}
+		if cpuStats.FlowLimitCount != nil {
+			ch <- prometheus.MustNewConstMetric(c.flowLimitCount, prometheus.CounterValue, float64(*cpuStats.FlowLimitCount), cpu)
+		}
+		if cpuStats.BacklogLen != nil {
+			ch <- prometheus.MustNewConstMetric(c.backlogLen, prometheus.GaugeValue, float64(*cpuStats.BacklogLen), cpu)
+		}
+	}
+
+	for _, name := range softnetSysctls {
+		value, err := readUintFromFile(procFilePath("sys/net/core/" + name))
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "failed to read sysctl", "name", name, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.sysctls[name], prometheus.GaugeValue, float64(value))
 	}
 
 	return nil
 }
+
+// softnetStat is a line of /proc/net/softnet_stat. Fields which are not shown
+// by the kernel are nil.
+type softnetStat struct {
+	CPU            uint32
+	Processed      uint32
+	Dropped        uint32
+	TimeSqueezed   uint32
+	ReceivedRPS    *uint32
+	FlowLimitCount *uint32
+	BacklogLen     *uint32
+}
+
+func readSoftnetStats(path string) ([]softnetStat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseSoftnetStats(file)
+}
+
+// parseSoftnetStats parses /proc/net/softnet_stat, which has a line of
+// hexadecimal counters for each online CPU, see softnet_seq_show() in the
+// kernel. Columns were added over time: received_rps in 2.6.35,
+// flow_limit_count in 3.11, and the backlog length and CPU number in 5.10.
+// Before 5.10, CPUs are numbered by line, which is wrong if CPUs are offline.
+func parseSoftnetStats(r io.Reader) ([]softnetStat, error) {
+	const minColumns = 9
+
+	var stats []softnetStat
+	scanner := bufio.NewScanner(r)
+	for line := uint32(0); scanner.Scan(); line++ {
+		columns := strings.Fields(scanner.Text())
+		if len(columns) < minColumns {
+			return nil, fmt.Errorf("%d columns were detected, but at least %d were expected", len(columns), minColumns)
+		}
+
+		values := make([]uint32, len(columns))
+		for i, column := range columns {
+			v, err := strconv.ParseUint(column, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid softnet column %q: %w", column, err)
+			}
+			values[i] = uint32(v)
+		}
+
+		stat := softnetStat{
+			CPU:          line,
+			Processed:    values[0],
+			Dropped:      values[1],
+			TimeSqueezed: values[2],
+		}
+		if len(values) > 9 {
+			stat.ReceivedRPS = &values[9]
+		}
+		if len(values) > 10 {
+			stat.FlowLimitCount = &values[10]
+		}
+		if len(values) > 12 {
+			stat.BacklogLen = &values[11]
+			stat.CPU = values[12]
+		}
+		stats = append(stats, stat)
+	}
+	return stats, scanner.Err()
+}
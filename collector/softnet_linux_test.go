@@ -0,0 +1,50 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nosoftnet
+
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSoftnetStats(t *testing.T) {
+	u := func(v uint32) *uint32 { return &v }
+	for kernel, want := range map[string]softnetStat{
+		"3.10": {CPU: 1, Processed: 0xdfb82, Dropped: 0x29, TimeSqueezed: 0xa, ReceivedRPS: u(2)},
+		"4.19": {CPU: 1, Processed: 0xdfb82, Dropped: 0x29, TimeSqueezed: 0xa, ReceivedRPS: u(2), FlowLimitCount: u(1)},
+		// CPU 1 is offline.
+		"5.10": {CPU: 2, Processed: 0xdfb82, Dropped: 0x29, TimeSqueezed: 0xa, ReceivedRPS: u(2), FlowLimitCount: u(1), BacklogLen: u(4)},
+	} {
+		stats, err := readSoftnetStats("fixtures/softnet/kernel-" + kernel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("%s: want 2 CPUs, got %d", kernel, len(stats))
+		}
+		if stats[0].CPU != 0 || stats[0].Processed != 0x49279 {
+			t.Errorf("%s: unexpected first CPU %+v", kernel, stats[0])
+		}
+		if !reflect.DeepEqual(stats[1], want) {
+			t.Errorf("%s: want %+v, got %+v", kernel, want, stats[1])
+		}
+	}
+
+	if _, err := parseSoftnetStats(strings.NewReader("00000001 00000002 00000003\n")); err == nil {
+		t.Error("expected error for too few columns, got none")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nosysctl
+
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	sysctlInclude     = kingpin.Flag("collector.sysctl.include", "Numeric sysctl to expose, in the form <key>[:<name>,...] where key may contain glob patterns and the names are used for the values of multi-value sysctls (e.g. net.ipv4.tcp_rmem:min,default,max). Can be repeated.").Strings()
+	sysctlIncludeInfo = kingpin.Flag("collector.sysctl.include-info", "String sysctl to expose as info metric, key may contain glob patterns. Can be repeated.").Strings()
+)
+
+type sysctlCollector struct {
+	numeric   []sysctlEntry
+	info      []sysctlEntry
+	valueDesc *prometheus.Desc
+	infoDesc  *prometheus.Desc
+	logger    log.Logger
+}
+
+// sysctlEntry is a sysctl key or glob pattern, with optional names for the
+// values of multi-value sysctls.
+type sysctlEntry struct {
+	pattern string
+	names   []string
+}
+
+func init() {
+	registerCollector("sysctl", defaultDisabled, NewSysctlCollector)
+}
+
+// NewSysctlCollector returns a new Collector exposing sysctl values.
+func NewSysctlCollector(logger log.Logger) (Collector, error) {
+	c := &sysctlCollector{
+		valueDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sysctl", "value"),
+			"Value of a numeric sysctl, by the name or else the position of the value for multi-value sysctls.",
+			[]string{"name", "index"}, nil,
+		),
+		infoDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sysctl", "info"),
+			"Value of a string sysctl, with a value of 1.",
+			[]string{"name", "value"}, nil,
+		),
+		logger: logger,
+	}
+	for _, spec := range *sysctlInclude {
+		entry, err := parseSysctlEntry(spec)
+		if err != nil {
+			return nil, err
+		}
+		c.numeric = append(c.numeric, entry)
+	}
+	for _, spec := range *sysctlIncludeInfo {
+		if _, err := filepath.Match(spec, ""); err != nil {
+			return nil, fmt.Errorf("invalid sysctl pattern %q: %w", spec, err)
+		}
+		c.info = append(c.info, sysctlEntry{pattern: spec})
+	}
+	return c, nil
+}
+
+func parseSysctlEntry(spec string) (sysctlEntry, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	entry := sysctlEntry{pattern: parts[0]}
+	if _, err := filepath.Match(entry.pattern, ""); err != nil || entry.pattern == "" {
+		return entry, fmt.Errorf("invalid sysctl pattern %q", spec)
+	}
+	if len(parts) == 2 {
+		entry.names = strings.Split(parts[1], ",")
+		for _, name := range entry.names {
+			if name == "" {
+				return entry, fmt.Errorf("invalid sysctl value names %q", spec)
+			}
+		}
+	}
+	return entry, nil
+}
+
+func (c *sysctlCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, entry := range c.numeric {
+		keys, err := globSysctl(entry.pattern)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			level.Debug(c.logger).Log("msg", "no sysctl matches pattern", "pattern", entry.pattern)
+		}
+		for _, key := range keys {
+			var values []sysctlValue
+			raw, err := readSysctlValues(key)
+			if err == nil {
+				values, err = parseSysctlValues(key, entry.names, raw)
+			}
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "skipping sysctl", "name", key, "err", err)
+				continue
+			}
+			for _, v := range values {
+				ch <- prometheus.MustNewConstMetric(c.valueDesc, prometheus.GaugeValue, v.value, key, v.index)
+			}
+		}
+	}
+
+	for _, entry := range c.info {
+		keys, err := globSysctl(entry.pattern)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			level.Debug(c.logger).Log("msg", "no sysctl matches pattern", "pattern", entry.pattern)
+		}
+		for _, key := range keys {
+			value, err := ioutil.ReadFile(procFilePath(sysctlPath(key)))
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "skipping sysctl", "name", key, "err", err)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.infoDesc, prometheus.GaugeValue, 1, key, strings.TrimSpace(string(value)))
+		}
+	}
+	return nil
+}
+
+// sysctlValue is a value of a numeric sysctl. Index is the name of the value
+// if names are given, its position for multi-value sysctls, or else empty.
+type sysctlValue struct {
+	index string
+	value float64
+}
+
+// parseSysctlValues parses the values of a numeric sysctl.
+func parseSysctlValues(key string, names []string, values []string) ([]sysctlValue, error) {
+	if len(names) > 0 && len(names) != len(values) {
+		return nil, fmt.Errorf("sysctl %s has %d values, but %d names were given", key, len(values), len(names))
+	}
+
+	parsed := make([]sysctlValue, len(values))
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of sysctl %s: %w", v, key, err)
+		}
+		parsed[i].value = f
+		switch {
+		case len(names) > 0:
+			parsed[i].index = names[i]
+		case len(values) > 1:
+			parsed[i].index = strconv.Itoa(i)
+		}
+	}
+	return parsed, nil
+}
+
+// sysctlPath returns the path of a sysctl relative to /proc. Like sysctl(8),
+// keys may be separated by dots or slashes, the latter allowing keys with
+// dots such as net/ipv4/conf/eth0.100/rp_filter.
+func sysctlPath(key string) string {
+	if !strings.Contains(key, "/") {
+		key = strings.Replace(key, ".", "/", -1)
+	}
+	return filepath.Join("sys", key)
+}
+
+// globSysctl returns the keys of the sysctls matching a pattern, separated by
+// dots unless a key has dots itself.
+func globSysctl(pattern string) ([]string, error) {
+	root := procFilePath("sys")
+	paths, err := filepath.Glob(procFilePath(sysctlPath(pattern)))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(rel, ".") {
+			rel = strings.Replace(rel, "/", ".", -1)
+		}
+		keys = append(keys, rel)
+	}
+	return keys, nil
+}
+
+func readSysctlValues(key string) ([]string, error) {
+	b, err := ioutil.ReadFile(procFilePath(sysctlPath(key)))
+	if err != nil {
+		return nil, err
+	}
+	values := strings.Fields(string(b))
+	if len(values) == 0 {
+		return nil, fmt.Errorf("sysctl %s is empty", key)
+	}
+	return values, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nosysctl
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestParseSysctlEntry(t *testing.T) {
+	for spec, want := range map[string]sysctlEntry{
+		"vm.swappiness":                     {pattern: "vm.swappiness"},
+		"net.ipv4.conf.*.rp_filter":         {pattern: "net.ipv4.conf.*.rp_filter"},
+		"net.ipv4.tcp_rmem:min,default,max": {pattern: "net.ipv4.tcp_rmem", names: []string{"min", "default", "max"}},
+	} {
+		got, err := parseSysctlEntry(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want %+v, got %+v", spec, want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "net.ipv4.conf.[.rp_filter", "net.ipv4.tcp_rmem:min,,max"} {
+		if _, err := parseSysctlEntry(spec); err == nil {
+			t.Errorf("%q: expected error, got none", spec)
+		}
+	}
+}
+
+func TestGlobSysctl(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for pattern, want := range map[string][]string{
+		"vm.swappiness":                    {"vm.swappiness"},
+		"net.ipv4.conf.*.rp_filter":        {"net.ipv4.conf.all.rp_filter", "net.ipv4.conf.eth0.rp_filter", "net/ipv4/conf/eth0.100/rp_filter"},
+		"net/ipv4/conf/eth0.100/rp_filter": {"net/ipv4/conf/eth0.100/rp_filter"},
+		"vm.missing":                       {},
+	} {
+		keys, err := globSysctl(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s: want %v, got %v", pattern, want, keys)
+		}
+	}
+
+	values, err := readSysctlValues("net/ipv4/tcp_rmem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"4096", "131072", "6291456"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("want values %v, got %v", want, values)
+	}
+}
+
+func TestParseSysctlValues(t *testing.T) {
+	for _, test := range []struct {
+		values []string
+		names  []string
+		want   []sysctlValue
+	}{
+		{
+			values: []string{"60"},
+			want:   []sysctlValue{{value: 60}},
+		},
+		{
+			values: []string{"32768", "60999"},
+			want:   []sysctlValue{{index: "0", value: 32768}, {index: "1", value: 60999}},
+		},
+		{
+			values: []string{"4096", "131072", "6291456"},
+			names:  []string{"min", "default", "max"},
+			want:   []sysctlValue{{index: "min", value: 4096}, {index: "default", value: 131072}, {index: "max", value: 6291456}},
+		},
+	} {
+		got, err := parseSysctlValues("test", test.names, test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: want %+v, got %+v", test.values, test.want, got)
+		}
+	}
+
+	if _, err := parseSysctlValues("test", nil, []string{"1", "abc"}); err == nil {
+		t.Error("expected error for invalid value, got none")
+	}
+	if _, err := parseSysctlValues("test", []string{"min", "max"}, []string{"1", "2", "3"}); err == nil {
+		t.Error("expected error for mismatched names, got none")
+	}
+}
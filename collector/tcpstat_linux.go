@@ -25,13 +25,27 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	tcpstatSockDiag    = kingpin.Flag("collector.tcpstat.sock-diag", "Dump the TCP sockets via sock_diag netlink instead of parsing /proc/net/tcp and /proc/net/tcp6, which is faster with many sockets and adds round trip time and retransmit histograms.").Bool()
+	tcpstatLocalPorts  = kingpin.Flag("collector.tcpstat.local-ports", "Group of local ports to aggregate the sock_diag metrics by, in the form <name>:<port>[-<port>],... (e.g. web:80,443). Can be repeated, the first matching group is used and other ports are grouped as \"other\".").Strings()
+	tcpstatRemotePorts = kingpin.Flag("collector.tcpstat.remote-ports", "Group of remote ports to aggregate the sock_diag metrics by, in the form <name>:<port>[-<port>],... (e.g. db:5432,6379). Can be repeated, the first matching group is used and other ports are grouped as \"other\".").Strings()
+	tcpstatRTTBuckets  = kingpin.Flag("collector.tcpstat.rtt-buckets", "Comma separated upper bounds in seconds of the round trip time histogram buckets.").
+				Default("0.0005,0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.25,0.5,1").String()
+	tcpstatRetransmitBuckets = kingpin.Flag("collector.tcpstat.retransmit-buckets", "Comma separated upper bounds of the retransmitted segments histogram buckets.").
+					Default("0,1,2,5,10,25,50,100").String()
+	tcpstatSockDiagFixtures = kingpin.Flag("collector.tcpstat.sock-diag-fixtures", "test fixtures to use for tcpstat collector sock_diag metrics").Default("").Hidden().String()
 )
 
 type tcpConnectionState int
 
 const (
+	// Not a kernel state, exported as "unknown".
+	tcpUnknown tcpConnectionState = iota
 	// TCP_ESTABLISHED
-	tcpEstablished tcpConnectionState = iota + 1
+	tcpEstablished
 	// TCP_SYN_SENT
 	tcpSynSent
 	// TCP_SYN_RECV
@@ -59,8 +73,14 @@ const (
 )
 
 type tcpStatCollector struct {
-	desc   typedDesc
-	logger log.Logger
+	desc              typedDesc
+	rtt               *prometheus.Desc
+	retransmits       *prometheus.Desc
+	localPorts        tcpPortGroups
+	remotePorts       tcpPortGroups
+	rttBuckets        []float64
+	retransmitBuckets []float64
+	logger            log.Logger
 }
 
 func init() {
@@ -69,17 +89,63 @@ func init() {
 
 // NewTCPStatCollector returns a new Collector exposing network stats.
 func NewTCPStatCollector(logger log.Logger) (Collector, error) {
-	return &tcpStatCollector{
-		desc: typedDesc{prometheus.NewDesc(
+	c := &tcpStatCollector{logger: logger}
+	if !*tcpstatSockDiag {
+		if len(*tcpstatLocalPorts) > 0 || len(*tcpstatRemotePorts) > 0 {
+			return nil, fmt.Errorf("port groups require --collector.tcpstat.sock-diag")
+		}
+		c.desc = typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "tcp", "connection_states"),
 			"Number of connection states.",
 			[]string{"state"}, nil,
-		), prometheus.GaugeValue},
-		logger: logger,
-	}, nil
+		), prometheus.GaugeValue}
+		return c, nil
+	}
+
+	var err error
+	if c.localPorts, err = parseTCPPortGroups(*tcpstatLocalPorts); err != nil {
+		return nil, fmt.Errorf("invalid local port group: %w", err)
+	}
+	if c.remotePorts, err = parseTCPPortGroups(*tcpstatRemotePorts); err != nil {
+		return nil, fmt.Errorf("invalid remote port group: %w", err)
+	}
+	if c.rttBuckets, err = parseBuckets(*tcpstatRTTBuckets); err != nil {
+		return nil, fmt.Errorf("invalid round trip time buckets: %w", err)
+	}
+	if c.retransmitBuckets, err = parseBuckets(*tcpstatRetransmitBuckets); err != nil {
+		return nil, fmt.Errorf("invalid retransmit buckets: %w", err)
+	}
+
+	var groupLabels []string
+	if c.localPorts != nil {
+		groupLabels = append(groupLabels, "local_ports")
+	}
+	if c.remotePorts != nil {
+		groupLabels = append(groupLabels, "remote_ports")
+	}
+	c.desc = typedDesc{prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "tcp", "connection_states"),
+		"Number of connection states.",
+		append([]string{"state"}, groupLabels...), nil,
+	), prometheus.GaugeValue}
+	c.rtt = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "tcp", "rtt_seconds"),
+		"Smoothed round trip time of established connections.",
+		groupLabels, nil,
+	)
+	c.retransmits = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "tcp", "retransmitted_segments"),
+		"Segments retransmitted over the lifetime of established connections.",
+		groupLabels, nil,
+	)
+	return c, nil
 }
 
 func (c *tcpStatCollector) Update(ch chan<- prometheus.Metric) error {
+	if *tcpstatSockDiag {
+		return c.updateSockDiag(ch)
+	}
+
 	tcpStats, err := getTCPStats(procFilePath("net/tcp"))
 	if err != nil {
 		return fmt.Errorf("couldn't get tcpstats: %w", err)
@@ -104,6 +170,132 @@ func (c *tcpStatCollector) Update(ch chan<- prometheus.Metric) error {
 	return nil
 }
 
+type tcpPortGroupKey struct {
+	localPorts  string
+	remotePorts string
+}
+
+type tcpStateKey struct {
+	state tcpConnectionState
+	tcpPortGroupKey
+}
+
+func (c *tcpStatCollector) updateSockDiag(ch chan<- prometheus.Metric) error {
+	stat, err := newTCPSockDiagStater(*tcpstatSockDiagFixtures)
+	if err != nil {
+		return fmt.Errorf("couldn't dial sock_diag: %w", err)
+	}
+	defer stat.Close()
+
+	sockets, err := stat.Sockets()
+	if err != nil {
+		return fmt.Errorf("couldn't dump TCP sockets: %w", err)
+	}
+
+	states := map[tcpStateKey]float64{}
+	rtt := map[tcpPortGroupKey]*histogram{}
+	retransmits := map[tcpPortGroupKey]*histogram{}
+	for _, s := range sockets {
+		groups := tcpPortGroupKey{c.localPorts.group(s.LocalPort), c.remotePorts.group(s.RemotePort)}
+		states[tcpStateKey{s.State, groups}]++
+		states[tcpStateKey{tcpRxQueuedBytes, groups}] += float64(s.RxQueue)
+		// The send queue of listening sockets is their maximum backlog.
+		if s.State != tcpListen {
+			states[tcpStateKey{tcpTxQueuedBytes, groups}] += float64(s.TxQueue)
+		}
+
+		if s.State != tcpEstablished || s.Info == nil {
+			continue
+		}
+		if rtt[groups] == nil {
+			rtt[groups] = newHistogram(c.rttBuckets)
+			retransmits[groups] = newHistogram(c.retransmitBuckets)
+		}
+		rtt[groups].observe(float64(s.Info.RTT) / 1e6)
+		retransmits[groups].observe(float64(s.Info.TotalRetrans))
+	}
+
+	for k, v := range states {
+		ch <- c.desc.mustNewConstMetric(v, append([]string{k.state.String()}, c.groupLabelValues(k.tcpPortGroupKey)...)...)
+	}
+	for k, h := range rtt {
+		ch <- prometheus.MustNewConstHistogram(c.rtt, h.count, h.sum, h.cumulative(), c.groupLabelValues(k)...)
+	}
+	for k, h := range retransmits {
+		ch <- prometheus.MustNewConstHistogram(c.retransmits, h.count, h.sum, h.cumulative(), c.groupLabelValues(k)...)
+	}
+	return nil
+}
+
+// groupLabelValues returns the values of the port group labels, which only
+// exist for configured port groups.
+func (c *tcpStatCollector) groupLabelValues(k tcpPortGroupKey) []string {
+	var values []string
+	if c.localPorts != nil {
+		values = append(values, k.localPorts)
+	}
+	if c.remotePorts != nil {
+		values = append(values, k.remotePorts)
+	}
+	return values
+}
+
+type tcpPortRange struct {
+	first uint16
+	last  uint16
+}
+
+type tcpPortGroup struct {
+	name   string
+	ranges []tcpPortRange
+}
+
+// tcpPortGroups are named groups of ports, of which the first matching one is
+// used.
+type tcpPortGroups []tcpPortGroup
+
+func parseTCPPortGroups(specs []string) (tcpPortGroups, error) {
+	var groups tcpPortGroups
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not of the form <name>:<port>[-<port>],...", spec)
+		}
+		group := tcpPortGroup{name: parts[0]}
+		for _, field := range strings.Split(parts[1], ",") {
+			bounds := strings.SplitN(field, "-", 2)
+			first, err := strconv.ParseUint(bounds[0], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q in %q", bounds[0], spec)
+			}
+			last := first
+			if len(bounds) == 2 {
+				if last, err = strconv.ParseUint(bounds[1], 10, 16); err != nil || last < first {
+					return nil, fmt.Errorf("invalid port range %q in %q", field, spec)
+				}
+			}
+			group.ranges = append(group.ranges, tcpPortRange{uint16(first), uint16(last)})
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// group returns the name of the first group containing a port, or "other".
+func (g tcpPortGroups) group(port uint16) string {
+	if g == nil {
+		return ""
+	}
+	for _, group := range g {
+		for _, r := range group.ranges {
+			if port >= r.first && port <= r.last {
+				return group.name
+			}
+		}
+	}
+	return "other"
+}
+
 func getTCPStats(statsFile string) (map[tcpConnectionState]float64, error) {
 	file, err := os.Open(statsFile)
 	if err != nil {
@@ -14,9 +14,16 @@
 package collector
 
 import (
+	"encoding/binary"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
 )
 
 func Test_parseTCPStatsError(t *testing.T) {
@@ -121,3 +128,88 @@ func Test_getTCPStats(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTCPPortGroups(t *testing.T) {
+	groups, err := parseTCPPortGroups([]string{"web:80,443,8000-8999", "ssh:22", "all:1-65535"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for port, want := range map[uint16]string{80: "web", 8080: "web", 22: "ssh", 5432: "all", 0: "other"} {
+		if got := groups.group(port); got != want {
+			t.Errorf("want port %d in group %q, got %q", port, want, got)
+		}
+	}
+
+	if groups, err := parseTCPPortGroups(nil); err != nil || groups != nil {
+		t.Errorf("want no port groups, got %+v, %v", groups, err)
+	}
+	if got := tcpPortGroups(nil).group(80); got != "" {
+		t.Errorf("want no group without port groups, got %q", got)
+	}
+
+	for _, spec := range []string{"web", ":80", "web:", "web:http", "web:443-80", "web:1-70000"} {
+		if _, err := parseTCPPortGroups([]string{spec}); err == nil {
+			t.Errorf("want error for %q, got none", spec)
+		}
+	}
+}
+
+func TestDumpTCPSockets(t *testing.T) {
+	conn := nltest.Dial(func(req []netlink.Message) ([]netlink.Message, error) {
+		if req[0].Data[0] == unix.AF_INET6 {
+			return nltest.Error(int(unix.ENOENT), req)
+		}
+		if states := nlenc.Uint32(req[0].Data[4:8]); states != 0xffe {
+			t.Errorf("want states 0xffe, got %#x", states)
+		}
+		return nltest.Multipart([]netlink.Message{
+			{Header: req[0].Header, Data: inetDiagMessage(unix.AF_INET, tcpListen, 22, 0, 0, 128, nil)},
+			// TCP_BOUND_INACTIVE, which must not be counted as queued bytes.
+			{Header: req[0].Header, Data: inetDiagMessage(unix.AF_INET, 13, 8080, 0, 0, 0, nil)},
+			{Header: req[0].Header, Data: inetDiagMessage(unix.AF_INET, tcpEstablished, 22, 50000, 0, 36, &tcpSocketInfo{RTT: 1500, TotalRetrans: 2})},
+			{Header: req[0].Header, Data: inetDiagMessage(unix.AF_INET, tcpTimeWait, 43210, 443, 0, 0, nil)},
+			{Header: netlink.Header{Type: netlink.Done}},
+		})
+	})
+	defer conn.Close()
+
+	sockets, err := dumpTCPSockets(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tcpSocket{
+		{State: tcpListen, LocalPort: 22, TxQueue: 128},
+		{State: tcpUnknown, LocalPort: 8080},
+		{State: tcpEstablished, LocalPort: 22, RemotePort: 50000, TxQueue: 36, Info: &tcpSocketInfo{RTT: 1500, TotalRetrans: 2}},
+		{State: tcpTimeWait, LocalPort: 43210, RemotePort: 443},
+	}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("want %+v, got %+v", want, sockets)
+	}
+
+	if _, err := parseInetDiagMessage(make([]byte, inetDiagMsgLen-1)); err == nil {
+		t.Error("want error for short message, got none")
+	}
+}
+
+// inetDiagMessage encodes a struct inet_diag_msg, followed by a struct tcp_info
+// if info is not nil.
+func inetDiagMessage(family uint8, state tcpConnectionState, localPort, remotePort uint16, rxQueue, txQueue uint32, info *tcpSocketInfo) []byte {
+	b := make([]byte, inetDiagMsgLen)
+	b[0] = family
+	b[1] = uint8(state)
+	binary.BigEndian.PutUint16(b[4:6], localPort)
+	binary.BigEndian.PutUint16(b[6:8], remotePort)
+	nlenc.PutUint32(b[56:60], rxQueue)
+	nlenc.PutUint32(b[60:64], txQueue)
+	if info == nil {
+		return b
+	}
+
+	// Size of struct tcp_info as of Linux 5.4.
+	tcpInfo := make([]byte, 232)
+	tcpInfo[0] = uint8(state)
+	nlenc.PutUint32(tcpInfo[68:72], info.RTT)
+	nlenc.PutUint32(tcpInfo[100:104], info.TotalRetrans)
+	return append(b, nltest.MustMarshalAttributes([]netlink.Attribute{{Type: inetDiagInfo, Data: tcpInfo}})...)
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !notcpstat
+
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"golang.org/x/sys/unix"
+)
+
+// sock_diag message types, extensions and sizes, see
+// include/uapi/linux/sock_diag.h and include/uapi/linux/inet_diag.h.
+const (
+	sockDiagByFamily = 20
+
+	inetDiagInfo = 2
+
+	// Sizes of struct inet_diag_req_v2 and struct inet_diag_msg.
+	inetDiagReqV2Len = 56
+	inetDiagMsgLen   = 72
+
+	// Size of struct tcp_info up to and including tcpi_total_retrans.
+	tcpInfoTotalRetransLen = 104
+
+	// Bit mask of the states TCP_ESTABLISHED to TCP_CLOSING, leaving out the
+	// pseudo states TCP_NEW_SYN_RECV and TCP_BOUND_INACTIVE.
+	inetDiagTCPStates = 0xffe
+)
+
+// tcpSocket is a TCP socket as dumped by sock_diag. Info is nil for sockets
+// without a struct tcp_info, such as those in the TIME_WAIT state.
+type tcpSocket struct {
+	State      tcpConnectionState
+	LocalPort  uint16
+	RemotePort uint16
+	RxQueue    uint32
+	TxQueue    uint32
+	Info       *tcpSocketInfo
+}
+
+// tcpSocketInfo are the fields of a struct tcp_info used by the collector.
+type tcpSocketInfo struct {
+	// RTT is the smoothed round trip time in microseconds.
+	RTT          uint32
+	TotalRetrans uint32
+}
+
+// tcpSockDiagStater is an interface used to swap out the sock_diag netlink
+// family for end to end tests.
+type tcpSockDiagStater interface {
+	Close() error
+	Sockets() ([]tcpSocket, error)
+}
+
+// newTCPSockDiagStater determines if mocked test fixtures from files should be
+// used for the TCP sockets, or if sock_diag netlink should be used.
+func newTCPSockDiagStater(fixtures string) (tcpSockDiagStater, error) {
+	if fixtures != "" {
+		return &mockTCPSockDiagStater{fixtures: fixtures}, nil
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_INET_DIAG, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpSockDiag{conn: conn}, nil
+}
+
+var _ tcpSockDiagStater = &tcpSockDiag{}
+
+type tcpSockDiag struct {
+	conn *netlink.Conn
+}
+
+func (s *tcpSockDiag) Close() error { return s.conn.Close() }
+
+func (s *tcpSockDiag) Sockets() ([]tcpSocket, error) { return dumpTCPSockets(s.conn) }
+
+// dumpTCPSockets returns the IPv4 and IPv6 TCP sockets in all states, with
+// their struct tcp_info. IPv6 is skipped if the kernel doesn't support it.
+func dumpTCPSockets(conn *netlink.Conn) ([]tcpSocket, error) {
+	var sockets []tcpSocket
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		req := make([]byte, inetDiagReqV2Len)
+		req[0] = family
+		req[1] = unix.IPPROTO_TCP
+		req[2] = 1 << (inetDiagInfo - 1)
+		nlenc.PutUint32(req[4:8], inetDiagTCPStates)
+
+		msgs, err := conn.Execute(netlink.Message{
+			Header: netlink.Header{
+				Type:  sockDiagByFamily,
+				Flags: netlink.Request | netlink.Dump,
+			},
+			Data: req,
+		})
+		if err != nil {
+			if family == unix.AF_INET6 && netlink.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+		for _, m := range msgs {
+			s, err := parseInetDiagMessage(m.Data)
+			if err != nil {
+				return nil, err
+			}
+			sockets = append(sockets, *s)
+		}
+	}
+	return sockets, nil
+}
+
+// parseInetDiagMessage parses a struct inet_diag_msg and its attributes. The
+// ports are in network byte order, the other fields in host byte order.
+func parseInetDiagMessage(b []byte) (*tcpSocket, error) {
+	if len(b) < inetDiagMsgLen {
+		return nil, fmt.Errorf("short inet_diag message of %d bytes", len(b))
+	}
+	// States beyond TCP_CLOSING are not real TCP states and would clash with
+	// the queued bytes pseudo states, so they are counted as unknown.
+	state := tcpConnectionState(b[1])
+	if state > tcpClosing {
+		state = tcpUnknown
+	}
+	s := tcpSocket{
+		State:      state,
+		LocalPort:  binary.BigEndian.Uint16(b[4:6]),
+		RemotePort: binary.BigEndian.Uint16(b[6:8]),
+		RxQueue:    nlenc.Uint32(b[56:60]),
+		TxQueue:    nlenc.Uint32(b[60:64]),
+	}
+
+	ad, err := netlink.NewAttributeDecoder(b[inetDiagMsgLen:])
+	if err != nil {
+		return nil, err
+	}
+	for ad.Next() {
+		if ad.Type() != inetDiagInfo {
+			continue
+		}
+		// Older kernels have a shorter struct tcp_info.
+		if info := ad.Bytes(); len(info) >= tcpInfoTotalRetransLen {
+			s.Info = &tcpSocketInfo{
+				RTT:          nlenc.Uint32(info[68:72]),
+				TotalRetrans: nlenc.Uint32(info[100:104]),
+			}
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+var _ tcpSockDiagStater = &mockTCPSockDiagStater{}
+
+// mockTCPSockDiagStater reads the sock_diag messages recorded in the fixtures
+// for each address family.
+type mockTCPSockDiagStater struct {
+	fixtures string
+}
+
+func (s *mockTCPSockDiagStater) Close() error { return nil }
+
+func (s *mockTCPSockDiagStater) Sockets() ([]tcpSocket, error) {
+	var sockets []tcpSocket
+	for _, name := range []string{"inet.hex", "inet6.hex"} {
+		msgs, err := readNetlinkFixture(filepath.Join(s.fixtures, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			sock, err := parseInetDiagMessage(m.Data)
+			if err != nil {
+				return nil, err
+			}
+			sockets = append(sockets, *sock)
+		}
+	}
+	return sockets, nil
+}
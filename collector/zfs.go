@@ -38,6 +38,12 @@ type zfsCollector struct {
 	linuxZpoolIoPath     string
 	linuxZpoolObjsetPath string
 	linuxPathMap         map[string]string
+	poolState            *prometheus.Desc
+	vdevState            *prometheus.Desc
+	vdevErrors           *prometheus.Desc
+	scanStartTime        *prometheus.Desc
+	scanEndTime          *prometheus.Desc
+	scanState            *prometheus.Desc
 	logger               log.Logger
 }
 
@@ -60,6 +66,36 @@ func NewZFSCollector(logger log.Logger) (Collector, error) {
 			"zfs_zfetch":      "zfetchstats",
 			"zfs_zil":         "zil",
 		},
+		poolState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "state"),
+			"Whether the pool is in the given state.",
+			[]string{"zpool", "state"}, nil,
+		),
+		vdevState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "vdev_state"),
+			"Whether the vdev is in the given state.",
+			[]string{"zpool", "vdev", "state"}, nil,
+		),
+		vdevErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "vdev_errors_total"),
+			"Number of I/O errors of a vdev by type.",
+			[]string{"zpool", "vdev", "type"}, nil,
+		),
+		scanStartTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "scan_start_time_seconds"),
+			"Start time of the last scrub or resilver of the pool, in unixtime.",
+			[]string{"zpool", "function"}, nil,
+		),
+		scanEndTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "scan_end_time_seconds"),
+			"End time of the last scrub or resilver of the pool, in unixtime.",
+			[]string{"zpool", "function"}, nil,
+		),
+		scanState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "zfs_zpool", "scan_state"),
+			"Whether the last scrub or resilver of the pool is in the given state.",
+			[]string{"zpool", "function", "state"}, nil,
+		),
 		logger: logger,
 	}, nil
 }
@@ -77,7 +113,10 @@ func (c *zfsCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 
 	// Pool stats
-	return c.updatePoolStats(ch)
+	if err := c.updatePoolStats(ch); err != nil {
+		return err
+	}
+	return c.updatePoolStatus(ch)
 }
 
 func (s zfsSysctl) metricName() string {
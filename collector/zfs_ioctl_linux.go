@@ -0,0 +1,356 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nozfs
+
+package collector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	zfsIocPoolStats = 'Z'<<8 + 0x05
+
+	// Offsets in zfs_cmd_t, see include/sys/zfs_ioctl.h. The buffer is larger
+	// than the structure, which the kernel copies in and out in full.
+	zfsCmdSize              = 32 << 10
+	zfsCmdNameSize          = 4096
+	zfsCmdNvlistDstOffset   = 4112
+	zfsCmdNvlistDstSzOffset = 4120
+
+	zfsPoolStatsBufferSize = 256 << 10
+)
+
+// vdev_stat_t indexes, see include/sys/fs/zfs.h.
+const (
+	zfsVdevStatState          = 1
+	zfsVdevStatReadErrors     = 20
+	zfsVdevStatWriteErrors    = 21
+	zfsVdevStatChecksumErrors = 22
+)
+
+// pool_scan_stat_t indexes, see include/sys/fs/zfs.h.
+const (
+	zfsScanStatFunc      = 0
+	zfsScanStatState     = 1
+	zfsScanStatStartTime = 2
+	zfsScanStatEndTime   = 3
+)
+
+// zfsVdevStates are the names of vdev_state_t values, as shown by zpool status.
+var zfsVdevStates = []string{"UNKNOWN", "CLOSED", "OFFLINE", "REMOVED", "UNAVAIL", "FAULTED", "DEGRADED", "ONLINE"}
+
+var (
+	zfsScanFuncs  = []string{"none", "scrub", "resilver"}
+	zfsScanStates = []string{"none", "scanning", "finished", "canceled"}
+)
+
+// zfsVdevStatus is the state and error counters of a vdev.
+type zfsVdevStatus struct {
+	Name           string
+	State          string
+	ReadErrors     uint64
+	WriteErrors    uint64
+	ChecksumErrors uint64
+}
+
+// zfsScanStatus is the status of the last scrub or resilver of a pool.
+type zfsScanStatus struct {
+	Function  string
+	State     string
+	StartTime uint64
+	EndTime   uint64
+}
+
+// zfsPoolStatus is the status of a pool and its vdevs.
+type zfsPoolStatus struct {
+	Vdevs []zfsVdevStatus
+	// Scan is nil if the pool was never scrubbed or resilvered.
+	Scan *zfsScanStatus
+}
+
+// zfsPoolStater is an interface used to swap out the ZFS control device for
+// end to end tests.
+type zfsPoolStater interface {
+	Close() error
+	PoolStatus(pool string) (*zfsPoolStatus, error)
+}
+
+// newZFSPoolStater determines if mocked test fixtures from files should be
+// used for collecting pool status, or if the ZFS control device should be used.
+func newZFSPoolStater(fixtures string) (zfsPoolStater, error) {
+	if fixtures != "" {
+		return &mockZFSPoolStater{
+			fixtures: fixtures,
+		}, nil
+	}
+
+	fd, err := unix.Open("/dev/zfs", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &zfsDevice{fd: fd}, nil
+}
+
+var _ zfsPoolStater = &zfsDevice{}
+
+type zfsDevice struct {
+	fd int
+}
+
+func (d *zfsDevice) Close() error { return unix.Close(d.fd) }
+
+func (d *zfsDevice) PoolStatus(pool string) (*zfsPoolStatus, error) {
+	if len(pool) >= zfsCmdNameSize {
+		return nil, fmt.Errorf("pool name %q too long", pool)
+	}
+
+	size := uint64(zfsPoolStatsBufferSize)
+	for {
+		cmd := make([]byte, zfsCmdSize)
+		dst := make([]byte, size)
+		copy(cmd, pool)
+		*(*uint64)(unsafe.Pointer(&cmd[zfsCmdNvlistDstOffset])) = uint64(uintptr(unsafe.Pointer(&dst[0])))
+		*(*uint64)(unsafe.Pointer(&cmd[zfsCmdNvlistDstSzOffset])) = size
+
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(d.fd), zfsIocPoolStats, uintptr(unsafe.Pointer(&cmd[0])))
+		runtime.KeepAlive(dst)
+		// The kernel returns the required size if the buffer is too small.
+		if errno == unix.ENOMEM {
+			needed := *(*uint64)(unsafe.Pointer(&cmd[zfsCmdNvlistDstSzOffset]))
+			if needed <= size {
+				return nil, errno
+			}
+			size = needed
+			continue
+		}
+		// A faulted pool still returns its configuration.
+		if errno != 0 && errno != unix.EIO && errno != unix.ENXIO {
+			return nil, errno
+		}
+
+		n := *(*uint64)(unsafe.Pointer(&cmd[zfsCmdNvlistDstSzOffset]))
+		if n > size {
+			return nil, fmt.Errorf("invalid pool stats size %d", n)
+		}
+		config, err := decodeNVList(dst[:n])
+		if err != nil {
+			return nil, err
+		}
+		return parseZFSPoolConfig(pool, config)
+	}
+}
+
+// parseZFSPoolConfig returns the status of a pool from its configuration
+// nvlist.
+func parseZFSPoolConfig(pool string, config map[string]interface{}) (*zfsPoolStatus, error) {
+	tree, ok := config["vdev_tree"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("pool configuration has no vdev tree")
+	}
+
+	var status zfsPoolStatus
+	appendZFSVdevs(&status, map[string]bool{}, pool, tree)
+
+	if stats, ok := tree["scan_stats"].([]uint64); ok && len(stats) > zfsScanStatEndTime && stats[zfsScanStatFunc] != 0 {
+		status.Scan = &zfsScanStatus{
+			Function:  zfsEnumName(zfsScanFuncs, stats[zfsScanStatFunc]),
+			State:     zfsEnumName(zfsScanStates, stats[zfsScanStatState]),
+			StartTime: stats[zfsScanStatStartTime],
+			EndTime:   stats[zfsScanStatEndTime],
+		}
+	}
+	return &status, nil
+}
+
+// appendZFSVdevs appends the status of a vdev and its children, with the same
+// names as zpool status. A hot spare in use is both a child of a spare vdev and
+// one of the spares, and is only appended the first time.
+func appendZFSVdevs(status *zfsPoolStatus, seen map[string]bool, name string, vdev map[string]interface{}) {
+	if seen[name] {
+		return
+	}
+	seen[name] = true
+
+	if stats, ok := vdev["vdev_stats"].([]uint64); ok && len(stats) > zfsVdevStatChecksumErrors {
+		status.Vdevs = append(status.Vdevs, zfsVdevStatus{
+			Name:           name,
+			State:          zfsEnumName(zfsVdevStates, stats[zfsVdevStatState]),
+			ReadErrors:     stats[zfsVdevStatReadErrors],
+			WriteErrors:    stats[zfsVdevStatWriteErrors],
+			ChecksumErrors: stats[zfsVdevStatChecksumErrors],
+		})
+	}
+
+	for _, key := range []string{"children", "l2cache", "spares"} {
+		children, _ := vdev[key].([]map[string]interface{})
+		for _, child := range children {
+			appendZFSVdevs(status, seen, zfsVdevName(child), child)
+		}
+	}
+}
+
+// zfsVdevName returns the name of a vdev, which is the device of leaf vdevs
+// and the type and ID of others, e.g. mirror-0.
+func zfsVdevName(vdev map[string]interface{}) string {
+	if path, ok := vdev["path"].(string); ok {
+		return filepath.Base(path)
+	}
+	typ, _ := vdev["type"].(string)
+	if parity, ok := vdev["nparity"].(uint64); ok && typ == "raidz" {
+		typ = fmt.Sprintf("%s%d", typ, parity)
+	}
+	if id, ok := vdev["id"].(uint64); ok {
+		return fmt.Sprintf("%s-%d", typ, id)
+	}
+	return typ
+}
+
+func zfsEnumName(names []string, v uint64) string {
+	if v < uint64(len(names)) {
+		return names[v]
+	}
+	return fmt.Sprintf("unknown_%d", v)
+}
+
+// nvpair data types, see include/sys/nvpair.h.
+const (
+	nvTypeUint64      = 8
+	nvTypeString      = 9
+	nvTypeUint64Array = 16
+	nvTypeNVList      = 19
+	nvTypeNVListArray = 20
+)
+
+// decodeNVList decodes a natively encoded nvlist, as returned by ZFS ioctls.
+// Only the value types needed for pool status are decoded, others are
+// skipped.
+func decodeNVList(b []byte) (map[string]interface{}, error) {
+	if len(b) < 4 {
+		return nil, errors.New("nvlist too short")
+	}
+	if b[0] != 0 {
+		return nil, fmt.Errorf("unsupported nvlist encoding %d", b[0])
+	}
+	d := nvlistDecoder{b: b, off: 4, order: binary.BigEndian}
+	if b[1] == 1 {
+		d.order = binary.LittleEndian
+	}
+	return d.nvlist()
+}
+
+type nvlistDecoder struct {
+	b     []byte
+	off   int
+	order binary.ByteOrder
+}
+
+func (d *nvlistDecoder) nvlist() (map[string]interface{}, error) {
+	// Skip nvl_version and nvl_nvflag.
+	d.off += 8
+
+	nvl := map[string]interface{}{}
+	for {
+		if d.off+4 > len(d.b) {
+			return nil, errors.New("nvlist truncated")
+		}
+		size := int(d.order.Uint32(d.b[d.off:]))
+		if size == 0 {
+			// The end of a list is marked by an empty pair.
+			d.off += 4
+			return nvl, nil
+		}
+		if size < 16 || d.off+size > len(d.b) {
+			return nil, fmt.Errorf("invalid nvpair size %d at offset %d", size, d.off)
+		}
+		pair := d.b[d.off : d.off+size]
+		d.off += size
+
+		nameSize := int(d.order.Uint16(pair[4:6]))
+		elems := int(d.order.Uint32(pair[8:12]))
+		typ := d.order.Uint32(pair[12:16])
+		valueOffset := 16 + (nameSize+7)&^7
+		if nameSize == 0 || valueOffset > size {
+			return nil, fmt.Errorf("invalid nvpair name size %d", nameSize)
+		}
+		name := string(pair[16 : 16+nameSize-1])
+		value := pair[valueOffset:]
+
+		switch typ {
+		case nvTypeUint64:
+			if len(value) < 8 {
+				return nil, fmt.Errorf("nvpair %s truncated", name)
+			}
+			nvl[name] = d.order.Uint64(value)
+		case nvTypeString:
+			nvl[name] = bytesToString(value)
+		case nvTypeUint64Array:
+			if len(value) < elems*8 {
+				return nil, fmt.Errorf("nvpair %s truncated", name)
+			}
+			a := make([]uint64, elems)
+			for i := range a {
+				a[i] = d.order.Uint64(value[i*8:])
+			}
+			nvl[name] = a
+		case nvTypeNVList:
+			// Embedded lists follow their pair.
+			v, err := d.nvlist()
+			if err != nil {
+				return nil, err
+			}
+			nvl[name] = v
+		case nvTypeNVListArray:
+			a := make([]map[string]interface{}, elems)
+			for i := range a {
+				v, err := d.nvlist()
+				if err != nil {
+					return nil, err
+				}
+				a[i] = v
+			}
+			nvl[name] = a
+		}
+	}
+}
+
+var _ zfsPoolStater = &mockZFSPoolStater{}
+
+type mockZFSPoolStater struct {
+	fixtures string
+}
+
+func (s *mockZFSPoolStater) Close() error { return nil }
+
+func (s *mockZFSPoolStater) PoolStatus(pool string) (*zfsPoolStatus, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.fixtures, pool+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var status zfsPoolStatus
+	if err := json.Unmarshal(b, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
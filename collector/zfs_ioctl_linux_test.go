@@ -0,0 +1,170 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nozfs
+
+package collector
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// nvpair is a pair of a natively encoded test nvlist.
+type nvpair struct {
+	name  string
+	typ   uint32
+	value interface{}
+}
+
+// encodeNVList encodes pairs the way nvlist_pack() does with NV_ENCODE_NATIVE
+// on a little endian host.
+func encodeNVList(pairs []nvpair) []byte {
+	return append([]byte{0, 1, 0, 0}, encodeNVListBody(pairs)...)
+}
+
+func encodeNVListBody(pairs []nvpair) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 8) // nvl_version and nvl_nvflag
+	for _, p := range pairs {
+		nameSize := len(p.name) + 1
+		header := make([]byte, 16+(nameSize+7)&^7)
+		copy(header[16:], p.name)
+
+		var value, embedded []byte
+		elems := 1
+		switch v := p.value.(type) {
+		case uint64:
+			value = make([]byte, 8)
+			le.PutUint64(value, v)
+		case string:
+			value = make([]byte, (len(v)+1+7)&^7)
+			copy(value, v)
+		case []uint64:
+			elems = len(v)
+			value = make([]byte, 8*len(v))
+			for i, x := range v {
+				le.PutUint64(value[i*8:], x)
+			}
+		case []nvpair:
+			value = make([]byte, 24) // nvlist_t
+			embedded = encodeNVListBody(v)
+		case [][]nvpair:
+			elems = len(v)
+			value = make([]byte, 32*len(v)) // pointers and nvlist_t
+			for _, l := range v {
+				embedded = append(embedded, encodeNVListBody(l)...)
+			}
+		}
+		le.PutUint32(header[0:], uint32(len(header)+len(value)))
+		le.PutUint16(header[4:], uint16(nameSize))
+		le.PutUint32(header[8:], uint32(elems))
+		le.PutUint32(header[12:], p.typ)
+		b = append(append(append(b, header...), value...), embedded...)
+	}
+	return append(b, 0, 0, 0, 0)
+}
+
+func vdevStats(state, readErrors, writeErrors, checksumErrors uint64) []uint64 {
+	stats := make([]uint64, 40)
+	stats[zfsVdevStatState] = state
+	stats[zfsVdevStatReadErrors] = readErrors
+	stats[zfsVdevStatWriteErrors] = writeErrors
+	stats[zfsVdevStatChecksumErrors] = checksumErrors
+	return stats
+}
+
+func TestParseZFSPoolConfig(t *testing.T) {
+	disk := func(id uint64, path string, stats []uint64) []nvpair {
+		return []nvpair{
+			{"type", nvTypeString, "disk"},
+			{"id", nvTypeUint64, id},
+			{"path", nvTypeString, path},
+			{"whole_disk", 1, nil},
+			{"vdev_stats", nvTypeUint64Array, stats},
+		}
+	}
+	config := encodeNVList([]nvpair{
+		{"version", nvTypeUint64, uint64(5000)},
+		{"name", nvTypeString, "tank"},
+		{"vdev_tree", nvTypeNVList, []nvpair{
+			{"type", nvTypeString, "root"},
+			{"id", nvTypeUint64, uint64(0)},
+			{"vdev_stats", nvTypeUint64Array, vdevStats(6, 0, 0, 0)},
+			{"scan_stats", nvTypeUint64Array, []uint64{1, 2, 1592000000, 1592003600, 0, 0}},
+			{"children", nvTypeNVListArray, [][]nvpair{
+				{
+					{"type", nvTypeString, "raidz"},
+					{"id", nvTypeUint64, uint64(0)},
+					{"nparity", nvTypeUint64, uint64(1)},
+					{"vdev_stats", nvTypeUint64Array, vdevStats(6, 0, 0, 0)},
+					{"children", nvTypeNVListArray, [][]nvpair{
+						disk(0, "/dev/sda", vdevStats(7, 0, 0, 0)),
+						// sdc is a hot spare replacing the faulted sdb.
+						{
+							{"type", nvTypeString, "spare"},
+							{"id", nvTypeUint64, uint64(1)},
+							{"vdev_stats", nvTypeUint64Array, vdevStats(6, 0, 0, 0)},
+							{"children", nvTypeNVListArray, [][]nvpair{
+								disk(0, "/dev/sdb", vdevStats(5, 3, 152, 1)),
+								disk(1, "/dev/sdc", vdevStats(7, 0, 0, 0)),
+							}},
+						},
+					}},
+				},
+			}},
+			{"l2cache", nvTypeNVListArray, [][]nvpair{
+				disk(0, "/dev/nvme0n1", vdevStats(7, 0, 0, 0)),
+			}},
+			{"spares", nvTypeNVListArray, [][]nvpair{
+				disk(0, "/dev/sdc", vdevStats(7, 0, 0, 0)),
+				disk(1, "/dev/sdd", vdevStats(7, 0, 0, 0)),
+			}},
+		}},
+		{"pool_guid", nvTypeUint64, uint64(1234)},
+	})
+
+	nvl, err := decodeNVList(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nvl["pool_guid"]; got != uint64(1234) {
+		t.Errorf("want pool_guid 1234 after the embedded vdev tree, got %v", got)
+	}
+
+	status, err := parseZFSPoolConfig("tank", nvl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &zfsPoolStatus{
+		Vdevs: []zfsVdevStatus{
+			{Name: "tank", State: "DEGRADED"},
+			{Name: "raidz1-0", State: "DEGRADED"},
+			{Name: "sda", State: "ONLINE"},
+			{Name: "spare-1", State: "DEGRADED"},
+			{Name: "sdb", State: "FAULTED", ReadErrors: 3, WriteErrors: 152, ChecksumErrors: 1},
+			{Name: "sdc", State: "ONLINE"},
+			{Name: "nvme0n1", State: "ONLINE"},
+			{Name: "sdd", State: "ONLINE"},
+		},
+		Scan: &zfsScanStatus{Function: "scrub", State: "finished", StartTime: 1592000000, EndTime: 1592003600},
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("want %+v, got %+v", want, status)
+	}
+
+	if _, err := decodeNVList(config[:len(config)-8]); err == nil {
+		t.Error("expected error for truncated nvlist, got none")
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -26,8 +27,14 @@ import (
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
+var zfsPoolFixtures = kingpin.Flag("collector.zfs.pool-fixtures", "test fixtures to use for zfs collector pool status metrics").Default("").Hidden().String()
+
+// zfsPoolStates are the states of a pool, see zpool_pool_state_to_name().
+var zfsPoolStates = []string{"online", "degraded", "faulted", "offline", "removed", "unavail", "suspended"}
+
 // constants from https://github.com/zfsonlinux/zfs/blob/master/lib/libspl/include/sys/kstat.h
 // kept as strings for comparison thus avoiding conversion to int
 const (
@@ -120,6 +127,101 @@ func (c *zfsCollector) updatePoolStats(ch chan<- prometheus.Metric) error {
 	return nil
 }
 
+// updatePoolStatus exports the state of pools and the state and errors of
+// their vdevs.
+func (c *zfsCollector) updatePoolStatus(ch chan<- prometheus.Metric) error {
+	poolNames, err := zfsPoolNames(procFilePath(c.linuxProcpathBase))
+	if err != nil {
+		return err
+	}
+	if poolNames == nil {
+		return nil
+	}
+
+	stater, err := newZFSPoolStater(*zfsPoolFixtures)
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "Cannot open ZFS control device", "err", err)
+	} else {
+		defer stater.Close()
+	}
+
+	for _, poolName := range poolNames {
+		// The state kstat is available since ZFS on Linux 0.8.
+		if data, err := ioutil.ReadFile(procFilePath(filepath.Join(c.linuxProcpathBase, poolName, "state"))); err == nil {
+			state := strings.ToLower(strings.TrimSpace(string(data)))
+			for _, s := range zfsPoolStates {
+				ch <- prometheus.MustNewConstMetric(c.poolState, prometheus.GaugeValue, zfsStateValue(s, state), poolName, s)
+			}
+		}
+
+		if stater == nil {
+			continue
+		}
+		status, err := stater.PoolStatus(poolName)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "Cannot retrieve pool status", "zpool", poolName, "err", err)
+			continue
+		}
+		c.updateVdevStatus(ch, poolName, status)
+	}
+	return nil
+}
+
+// zfsPoolNames returns the names of the pools with kstats in dir, which has a
+// directory per imported pool.
+func zfsPoolNames(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (c *zfsCollector) updateVdevStatus(ch chan<- prometheus.Metric, poolName string, status *zfsPoolStatus) {
+	for _, vdev := range status.Vdevs {
+		state := strings.ToLower(vdev.State)
+		for _, s := range zfsVdevStates {
+			s = strings.ToLower(s)
+			ch <- prometheus.MustNewConstMetric(c.vdevState, prometheus.GaugeValue, zfsStateValue(s, state), poolName, vdev.Name, s)
+		}
+		for typ, v := range map[string]uint64{
+			"read":     vdev.ReadErrors,
+			"write":    vdev.WriteErrors,
+			"checksum": vdev.ChecksumErrors,
+		} {
+			ch <- prometheus.MustNewConstMetric(c.vdevErrors, prometheus.CounterValue, float64(v), poolName, vdev.Name, typ)
+		}
+	}
+
+	scan := status.Scan
+	if scan == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.scanStartTime, prometheus.GaugeValue, float64(scan.StartTime), poolName, scan.Function)
+	ch <- prometheus.MustNewConstMetric(c.scanEndTime, prometheus.GaugeValue, float64(scan.EndTime), poolName, scan.Function)
+	for _, s := range zfsScanStates[1:] {
+		ch <- prometheus.MustNewConstMetric(c.scanState, prometheus.GaugeValue, zfsStateValue(s, scan.State), poolName, scan.Function, s)
+	}
+}
+
+// zfsStateValue returns 1 if state is the current state of a pool or one of
+// its components, and 0 otherwise.
+func zfsStateValue(state, current string) float64 {
+	if state == current {
+		return 1
+	}
+	return 0
+}
+
 func (c *zfsCollector) parseProcfsFile(reader io.Reader, fmtExt string, handler func(zfsSysctl, uint64)) error {
 	scanner := bufio.NewScanner(reader)
 
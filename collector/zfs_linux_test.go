@@ -16,6 +16,7 @@ package collector
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -494,3 +495,17 @@ func TestVdevMirrorstatsParsing(t *testing.T) {
 		t.Fatal("VdevMirrorStats parsing handler was not called for some expected sysctls")
 	}
 }
+
+func TestZFSPoolNames(t *testing.T) {
+	names, err := zfsPoolNames("fixtures/proc/spl/kstat/zfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pool1", "poolz1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want pools %v, got %v", want, names)
+	}
+
+	if names, err := zfsPoolNames("fixtures/proc/spl/kstat/nonexistent"); err != nil || names != nil {
+		t.Errorf("want no pools and no error, got %v, %v", names, err)
+	}
+}
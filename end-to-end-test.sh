@@ -10,11 +10,14 @@ enabled_collectors=$(cat << COLLECTORS
   conntrack
   cpu
   cpufreq
+  devmapper
   diskstats
+  dmi
   drbd
   edac
   entropy
   filefd
+  firewall
   hwmon
   infiniband
   interrupts
@@ -25,16 +28,21 @@ enabled_collectors=$(cat << COLLECTORS
   meminfo
   meminfo_numa
   mountstats
+  neighbor
   netdev
   netstat
   nfs
   nfsd
+  os
   pressure
   qdisc
   rapl
+  route
   schedstat
   sockstat
   stat
+  sysctl
+  tcpstat
   thermal_zone
   textfile
   bonding
@@ -100,13 +108,36 @@ fi
 ./node_exporter \
   --path.procfs="collector/fixtures/proc" \
   --path.sysfs="collector/fixtures/sys" \
+  --path.rootfs="collector/fixtures" \
   $(for c in ${enabled_collectors}; do echo --collector.${c}  ; done) \
   $(for c in ${disabled_collectors}; do echo --no-collector.${c}  ; done) \
   --collector.textfile.directory="collector/fixtures/textfile/two_metric_files/" \
   --collector.wifi.fixtures="collector/fixtures/wifi" \
   --collector.qdisc.fixtures="collector/fixtures/qdisc/" \
+  --collector.btrfs.ioctl-fixtures="collector/fixtures/btrfs/" \
+  --collector.zfs.pool-fixtures="collector/fixtures/zfs/" \
+  --collector.neighbor.fixtures="collector/fixtures/neighbor/" \
+  --collector.route.fixtures="collector/fixtures/route/" \
+  --collector.ipvs.netlink \
+  --collector.firewall.fixtures="collector/fixtures/firewall/" \
+  --collector.tcpstat.sock-diag \
+  --collector.tcpstat.sock-diag-fixtures="collector/fixtures/tcpstat/" \
+  --collector.tcpstat.local-ports="web:80,443" \
+  --collector.tcpstat.local-ports="ssh:22" \
+  --collector.tcpstat.remote-ports="postgres:5432" \
+  --collector.ipvs.netlink-fixtures="collector/fixtures/ipvs/" \
+  --collector.sysctl.include="vm.swappiness" \
+  --collector.sysctl.include="kernel.pid_max" \
+  --collector.sysctl.include="fs.file-max" \
+  --collector.sysctl.include="net.core.somaxconn" \
+  --collector.sysctl.include="net.ipv4.ip_local_port_range" \
+  --collector.sysctl.include="net.ipv4.tcp_rmem:min,default,max" \
+  --collector.sysctl.include="net.ipv4.conf.*.rp_filter" \
+  --collector.sysctl.include-info="kernel.core_pattern" \
+  --collector.sysctl.include-info="kernel.seccomp.actions_avail" \
   --collector.netclass.ignored-devices="(bond0|dmz|int)" \
   --collector.cpu.info \
+  --collector.mountstats.latency-histograms \
   --web.listen-address "127.0.0.1:${port}" \
   --log.level="debug" > "${tmpdir}/node_exporter.log" 2>&1 &
 